@@ -0,0 +1,223 @@
+package glfs
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"os"
+	"strings"
+
+	"blobcache.io/blobcache/src/schema"
+)
+
+// EditOp is the operation performed by an Edit
+type EditOp string
+
+const (
+	// EditPut creates or replaces the entry at a path.
+	EditPut = EditOp("put")
+	// EditDelete removes the entry at a path.
+	EditDelete = EditOp("delete")
+	// EditChmod changes the mode of the entry at a path, and keeps its Ref.
+	EditChmod = EditOp("chmod")
+)
+
+// Edit is a single change to the entry at a path.
+type Edit struct {
+	Path string `json:"path"`
+	Op   EditOp `json:"op"`
+	// Mode and Ref are the new entry at Path. They are only used by EditPut, and EditChmod only uses Mode.
+	Mode os.FileMode `json:"mode,omitempty"`
+	Ref  *Ref        `json:"ref,omitempty"`
+	// Old is the Ref which is expected at Path in the base.
+	// If Old is nil, Path is expected to not exist in the base.
+	// For EditChmod, a nil Old means any entry is expected at Path.
+	Old *Ref `json:"old,omitempty"`
+}
+
+// ChangeSet is a list of Edits, which can be applied to a tree with Machine.Apply.
+// ChangeSets can be serialized as JSON.
+// The Refs in a ChangeSet are not synced along with it, the data they refer to must be synced separately.
+type ChangeSet []Edit
+
+// ChangeSetFromDiff collects the changes yielded by Machine.Diff into a ChangeSet.
+func ChangeSetFromDiff(changes iter.Seq2[Change, error]) (ChangeSet, error) {
+	var cs ChangeSet
+	for c, err := range changes {
+		if err != nil {
+			return nil, err
+		}
+		ed := Edit{Path: c.Path}
+		if c.Old != nil {
+			ed.Old = &c.Old.Ref
+		}
+		switch c.Kind {
+		case ChangeRemoved:
+			ed.Op = EditDelete
+		case ChangeModeChanged:
+			ed.Op = EditChmod
+			ed.Mode = c.New.FileMode
+			if c.Old.Ref.Type == TypeTree {
+				// the tree's children are changed, and checked, by the Edits which follow.
+				ed.Old = nil
+			}
+		default:
+			ed.Op = EditPut
+			ed.Mode = c.New.FileMode
+			ed.Ref = &c.New.Ref
+		}
+		cs = append(cs, ed)
+	}
+	return cs, nil
+}
+
+// Apply applies the edits in cs to base, and returns the new root.
+// If any path in base does not have the Ref expected by its Edit, then ErrPrecondition is returned and nothing is written.
+// When more than one Edit has the same Path, the last one is applied.
+//
+// Apply will call Sync to protect referential integrity in dst.
+func (ag *Machine) Apply(ctx context.Context, dst schema.WO, src schema.RO, base Ref, cs ChangeSet) (*Ref, error) {
+	edits, err := ag.checkApply(ctx, src, base, cs)
+	if err != nil {
+		return nil, err
+	}
+	ref, err := ag.applyTree(ctx, dst, src, &base, edits)
+	if err != nil {
+		return nil, err
+	}
+	if err := ag.Sync(ctx, dst, src, *ref); err != nil {
+		return nil, err
+	}
+	return ref, nil
+}
+
+// CheckApply checks that Apply would succeed, without writing anything.
+// It returns ErrPrecondition if the preconditions of any Edit in cs do not hold in base,
+// and other errors if cs is invalid, or cannot be applied to base.
+func (ag *Machine) CheckApply(ctx context.Context, src schema.RO, base Ref, cs ChangeSet) error {
+	_, err := ag.checkApply(ctx, src, base, cs)
+	return err
+}
+
+// checkApply validates cs, and checks it against base, by applying it without writing anything.
+// It returns the edits by path, for applyTree.
+func (ag *Machine) checkApply(ctx context.Context, src schema.RO, base Ref, cs ChangeSet) (map[string]Edit, error) {
+	edits := make(map[string]Edit, len(cs))
+	for _, ed := range cs {
+		p := CleanPath(ed.Path)
+		if p == "" {
+			return nil, fmt.Errorf("cannot apply edit to root")
+		}
+		switch ed.Op {
+		case EditPut:
+			if ed.Ref == nil {
+				return nil, fmt.Errorf("put at %q is missing a Ref", p)
+			}
+		case EditDelete, EditChmod:
+		default:
+			return nil, fmt.Errorf("unrecognized edit op %q", ed.Op)
+		}
+		edits[p] = ed
+	}
+	var failures []PreconditionFailure
+	for _, ed := range cs {
+		actual, err := ag.GetAtPath(ctx, src, base, ed.Path)
+		if err != nil && !IsErrNoEnt(err) {
+			return nil, err
+		}
+		switch {
+		case actual == nil && ed.Old == nil && ed.Op != EditChmod:
+		case actual != nil && ed.Old == nil && ed.Op == EditChmod:
+		case actual != nil && ed.Old != nil && actual.Equals(*ed.Old):
+		default:
+			failures = append(failures, PreconditionFailure{
+				Path:     ed.Path,
+				Expected: ed.Old,
+				Actual:   actual,
+			})
+		}
+	}
+	if len(failures) > 0 {
+		return nil, ErrPrecondition{Failures: failures}
+	}
+	if _, err := ag.applyTree(ctx, nil, src, &base, edits); err != nil {
+		return nil, err
+	}
+	return edits, nil
+}
+
+// applyTree applies edits, with paths relative to root.
+// If root is nil, the edits are applied to an empty tree.
+// If dst is nil, nothing is written, and the returned Ref is only a placeholder.
+func (ag *Machine) applyTree(ctx context.Context, dst schema.WO, src schema.RO, root *Ref, edits map[string]Edit) (*Ref, error) {
+	var tree []TreeEntry
+	if root != nil {
+		var err error
+		if tree, err = ag.GetTreeSlice(ctx, src, *root, 1e6); err != nil {
+			return nil, err
+		}
+	}
+	direct := map[string]Edit{}
+	nested := map[string]map[string]Edit{}
+	for p, ed := range edits {
+		name, rest, _ := strings.Cut(p, "/")
+		if rest == "" {
+			direct[name] = ed
+			continue
+		}
+		if nested[name] == nil {
+			nested[name] = map[string]Edit{}
+		}
+		nested[name][rest] = ed
+	}
+	m := make(map[string]TreeEntry, len(tree))
+	for _, ent := range tree {
+		m[ent.Name] = ent
+	}
+	for name, ed := range direct {
+		switch ed.Op {
+		case EditPut:
+			m[name] = TreeEntry{Name: name, FileMode: ed.Mode, Ref: *ed.Ref}
+		case EditDelete:
+			delete(m, name)
+		case EditChmod:
+			ent, exists := m[name]
+			if !exists {
+				return nil, fmt.Errorf("cannot chmod %q, it does not exist", name)
+			}
+			if ent.FileMode.IsDir() != ed.Mode.IsDir() {
+				return nil, fmt.Errorf("cannot chmod %q to %v, it is a %s", name, ed.Mode, ent.Ref.Type)
+			}
+			ent.FileMode = ed.Mode
+			m[name] = ent
+		}
+	}
+	for name, edits2 := range nested {
+		var subRoot *Ref
+		mode := getFileMode(Ref{Type: TypeTree})
+		if ent, exists := m[name]; exists {
+			if ent.Ref.Type != TypeTree {
+				return nil, fmt.Errorf("cannot apply edits beneath %q, it is a %s", name, ent.Ref.Type)
+			}
+			subRoot = &ent.Ref
+			mode = ent.FileMode
+		}
+		ref, err := ag.applyTree(ctx, dst, src, subRoot, edits2)
+		if err != nil {
+			return nil, err
+		}
+		m[name] = TreeEntry{Name: name, FileMode: mode, Ref: *ref}
+	}
+	tree2 := make([]TreeEntry, 0, len(m))
+	for _, ent := range m {
+		tree2 = append(tree2, ent)
+	}
+	SortTreeEntries(tree2)
+	if dst == nil {
+		return &Ref{Type: TypeTree}, nil
+	}
+	if err := ag.syncTreeEntries(ctx, dst, src, tree2); err != nil {
+		return nil, err
+	}
+	return ag.PostTreeSlice(ctx, dst, tree2)
+}
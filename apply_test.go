@@ -0,0 +1,114 @@
+package glfs
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestApply(t *testing.T) {
+	ctx := context.TODO()
+	s := newStore(t)
+	left := mustPostTree(t, s, map[string]Ref{
+		"config/app.yaml": mustPostBlob(t, s, []byte("v1")),
+		"config/old.yaml": mustPostBlob(t, s, []byte("old")),
+	})
+	right := mustPostTree(t, s, map[string]Ref{
+		"config/app.yaml": mustPostBlob(t, s, []byte("v2")),
+		"config/new.yaml": mustPostBlob(t, s, []byte("new")),
+	})
+	cs, err := ChangeSetFromDiff(defaultOp.Diff(ctx, s, left, s, right))
+	require.NoError(t, err)
+	require.Len(t, cs, 3)
+
+	data, err := json.Marshal(cs)
+	require.NoError(t, err)
+	var cs2 ChangeSet
+	require.NoError(t, json.Unmarshal(data, &cs2))
+	require.Equal(t, cs, cs2)
+
+	// applying to left produces right
+	out, err := defaultOp.Apply(ctx, s, s, left, cs2)
+	require.NoError(t, err)
+	require.Equal(t, right, *out)
+
+	// applying to another environment keeps its other files
+	env := mustPostTree(t, s, map[string]Ref{
+		"config/app.yaml": mustPostBlob(t, s, []byte("v1")),
+		"config/old.yaml": mustPostBlob(t, s, []byte("old")),
+		"env.txt":         mustPostBlob(t, s, []byte("staging")),
+	})
+	out, err = defaultOp.Apply(ctx, s, s, env, cs)
+	require.NoError(t, err)
+	expected := mustPostTree(t, s, map[string]Ref{
+		"config/app.yaml": mustPostBlob(t, s, []byte("v2")),
+		"config/new.yaml": mustPostBlob(t, s, []byte("new")),
+		"env.txt":         mustPostBlob(t, s, []byte("staging")),
+	})
+	require.Equal(t, expected, *out)
+
+	// applying again fails the preconditions
+	require.NoError(t, defaultOp.CheckApply(ctx, s, left, cs))
+	err = defaultOp.CheckApply(ctx, s, right, cs)
+	require.True(t, IsErrPrecondition(err))
+	_, err = defaultOp.Apply(ctx, s, s, right, cs)
+	require.True(t, IsErrPrecondition(err))
+	require.Len(t, err.(ErrPrecondition).Failures, 3)
+}
+
+func TestApplyChmod(t *testing.T) {
+	ctx := context.TODO()
+	s := newStore(t)
+	dir := func(mode os.FileMode, m map[string]Ref) Ref {
+		ref, err := defaultOp.PostTreeSlice(ctx, s, []TreeEntry{
+			{Name: "config", FileMode: os.ModeDir | mode, Ref: mustPostTree(t, s, m)},
+		})
+		require.NoError(t, err)
+		return *ref
+	}
+	v1 := mustPostBlob(t, s, []byte("v1"))
+	v2 := mustPostBlob(t, s, []byte("v2"))
+	env := mustPostBlob(t, s, []byte("staging"))
+	left := dir(0o755, map[string]Ref{"app.yaml": v1})
+	right := dir(0o700, map[string]Ref{"app.yaml": v2})
+	cs, err := ChangeSetFromDiff(defaultOp.Diff(ctx, s, left, s, right))
+	require.NoError(t, err)
+	require.Equal(t, ChangeSet{
+		{Path: "config", Op: EditChmod, Mode: os.ModeDir | 0o700},
+		{Path: "config/app.yaml", Op: EditPut, Mode: 0o644, Ref: &v2, Old: &v1},
+	}, cs)
+
+	// the base has different contents in the directory, which are kept.
+	base := dir(0o755, map[string]Ref{"app.yaml": v1, "env.yaml": env})
+	out, err := defaultOp.Apply(ctx, s, s, base, cs)
+	require.NoError(t, err)
+	require.Equal(t, dir(0o700, map[string]Ref{"app.yaml": v2, "env.yaml": env}), *out)
+
+	// the directory must exist.
+	_, err = defaultOp.Apply(ctx, s, s, mustPostTree(t, s, map[string]Ref{"other": v1}), cs[:1])
+	require.True(t, IsErrPrecondition(err))
+}
+
+func TestCheckApplyInvalid(t *testing.T) {
+	ctx := context.TODO()
+	s := newStore(t)
+	x := mustPostBlob(t, s, []byte("x"))
+	base := mustPostTree(t, s, map[string]Ref{"file.txt": x})
+	for _, cs := range []ChangeSet{
+		{{Path: "", Op: EditDelete, Old: &base}},
+		{{Path: "a.txt", Op: "rename"}},
+		{{Path: "a.txt", Op: EditPut}},
+		{{Path: "file.txt/a.txt", Op: EditPut, Ref: &x}},
+		{{Path: "file.txt", Op: EditChmod, Mode: os.ModeDir | 0o755}},
+		{{Path: "new", Op: EditPut, Ref: &x}, {Path: "new/a.txt", Op: EditPut, Ref: &x}},
+	} {
+		err := defaultOp.CheckApply(ctx, s, base, cs)
+		require.Error(t, err, "%v", cs)
+		require.False(t, IsErrPrecondition(err))
+		_, err2 := defaultOp.Apply(ctx, s, s, base, cs)
+		require.Equal(t, err, err2)
+	}
+}
@@ -0,0 +1,96 @@
+package bigblob
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"blobcache.io/blobcache/src/schema"
+)
+
+// NewWriterFrom returns a Writer which continues the blob at root.
+// Data written to the Writer is appended to the blob, and Finish returns the Root of the longer blob.
+// Every full block, and every complete index block, of root is reused.
+// Only the final partial block, and the index blocks along the right edge of the tree, are rewritten.
+//
+// If salt is the same as the one root was written with, then the result is identical to writing all the data with a single Writer.
+func (ag *Machine) NewWriterFrom(ctx context.Context, s schema.RW, salt *[32]byte, root Root) (*Writer, error) {
+	if root.BlockSize == 0 {
+		return nil, fmt.Errorf("block size cannot be zero")
+	}
+	if root.BlockSize > uint64(s.MaxSize()) {
+		return nil, fmt.Errorf("blob has block size %d, which is larger than the store's max size %d", root.BlockSize, s.MaxSize())
+	}
+	w := ag.newWriter(s, salt, int(root.BlockSize))
+	w.SetWriteContext(ctx)
+	bf := branchingFactor(root.BlockSize)
+	fullBlocks := root.Size / root.BlockSize
+	rootLevel := depth(root.Size, root.BlockSize)
+
+	// each level of the Writer holds the complete subtrees which have not been collected into an index yet.
+	levels := 1
+	for p := bf; p <= fullBlocks; p *= bf {
+		levels++
+	}
+	for i := 0; i < levels; i++ {
+		if i > 0 {
+			w.indexes = append(w.indexes, newIndex(w.blockSize))
+			w.counts = append(w.counts, 0)
+		}
+		subtrees := fullBlocks / pow(bf, uint64(i))
+		count := subtrees % bf
+		for j := uint64(0); j < count; j++ {
+			ref, err := ag.getNode(ctx, s, root, rootLevel, root.Ref, i, subtrees-count+j)
+			if err != nil {
+				return nil, err
+			}
+			w.indexes[i].Set(int(j), *ref)
+		}
+		w.counts[i] = int(count)
+	}
+	w.size = fullBlocks * root.BlockSize
+
+	if partial := root.Size - w.size; partial > 0 {
+		w.buf = make([]byte, partial)
+		if _, err := io.ReadFull(io.NewSectionReader(ag.NewReader(ctx, s, root), int64(w.size), int64(partial)), w.buf); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// getNode returns the Ref to the m-th node at target level, beneath x which is at level.
+func (ag *Machine) getNode(ctx context.Context, s schema.RO, root Root, level int, x Ref, target int, m uint64) (*Ref, error) {
+	if x.CID.IsZero() {
+		return &x, nil
+	}
+	if level == target {
+		if x.Equals(root.Ref) {
+			// the root is never stored as a hole, but the Writer would have a hole here.
+			var isHole bool
+			if err := ag.getF(ctx, s, x, func(data []byte) error {
+				isHole = allZero(data)
+				return nil
+			}); err != nil {
+				return nil, err
+			}
+			if isHole {
+				return &Ref{}, nil
+			}
+		}
+		return &x, nil
+	}
+	span := pow(branchingFactor(root.BlockSize), uint64(level-1-target))
+	var child Ref
+	if err := ag.getF(ctx, s, x, func(data []byte) error {
+		idx, err := newIndexUsing(data, int(root.BlockSize))
+		if err != nil {
+			return err
+		}
+		child = idx.Get(int(m / span))
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return ag.getNode(ctx, s, root, level-1, child, target, m%span)
+}
@@ -37,7 +37,13 @@ func (ag *Machine) ReadAt(ctx context.Context, s bcsdk.RO, x Root, offset int64,
 	if err != nil {
 		return n, err
 	}
-	if err := ag.getF(ctx, s, *ref, func(data []byte) error {
+	if ref.CID.IsZero() {
+		// the block is a hole
+		blockLen := min(x.BlockSize, x.Size-blockIndex*x.BlockSize)
+		n = int(min(uint64(len(buf)), blockLen-relOffset))
+		clear(buf[:n])
+		offset += int64(n)
+	} else if err := ag.getF(ctx, s, *ref, func(data []byte) error {
 		n = copy(buf[n:], data[relOffset:])
 		offset += int64(n)
 		return nil
@@ -50,8 +56,10 @@ func (ag *Machine) ReadAt(ctx context.Context, s bcsdk.RO, x Root, offset int64,
 	return n, nil
 }
 
+// getPiece returns the Ref for the data block at blockIndex.
+// If the block is in a hole, then the zero Ref is returned.
 func (ag *Machine) getPiece(ctx context.Context, s bcsdk.RO, root Ref, bf, level, blockIndex int) (*Ref, error) {
-	if level == 0 {
+	if level == 0 || root.CID.IsZero() {
 		return &root, nil
 	}
 	var ref Ref
@@ -85,11 +93,16 @@ type Writer struct {
 func (ag *Machine) NewWriter(s bcsdk.WO, salt *[32]byte) *Writer {
 	blockSize := s.MaxSize()
 	if ag.blockSize > 0 {
-		blockSize = ag.blockSize
+		blockSize = min(ag.blockSize, s.MaxSize())
 	}
-	if blockSize > s.MaxSize() {
-		panic(fmt.Sprintf("blockSize %d > maxSize %d", blockSize, s.MaxSize()))
+	if blockSize > 0 {
+		// depth assumes the branching factor is a power of two, so the block size is rounded down to one.
+		blockSize = 1 << (bits.Len(uint(blockSize)) - 1)
 	}
+	return ag.newWriter(s, salt, blockSize)
+}
+
+func (ag *Machine) newWriter(s bcsdk.WO, salt *[32]byte, blockSize int) *Writer {
 	if blockSize < 2*maxRefSize {
 		panic(fmt.Sprintf("blockSize cannot be < %d", 2*maxRefSize))
 	}
@@ -149,10 +162,38 @@ func (w *Writer) Finish(ctx context.Context) (*Root, error) {
 	}, nil
 }
 
+// WriteZeros writes n zero bytes.
+// It is equivalent to writing a slice of zeros, but whole blocks of zeros are added as holes without being buffered.
+func (w *Writer) WriteZeros(n uint64) error {
+	for n > 0 {
+		if len(w.buf) == 0 && n >= uint64(w.blockSize) {
+			if err := w.addRef(w.ctx, 0, Ref{}); err != nil {
+				return err
+			}
+			w.size += uint64(w.blockSize)
+			n -= uint64(w.blockSize)
+			continue
+		}
+		m := min(n, uint64(w.blockSize-len(w.buf)), uint64(len(zeros)))
+		if _, err := w.Write(zeros[:m]); err != nil {
+			return err
+		}
+		n -= m
+	}
+	return nil
+}
+
+// postBuf posts the buffered data as a block.
+// If the data is all zeros, nothing is posted, and a hole is added to the index instead.
 func (w *Writer) postBuf(ctx context.Context) error {
-	ref, err := w.ag.post(ctx, w.s, w.rawSalt, w.buf)
-	if err != nil {
-		return err
+	var ref *Ref
+	if allZero(w.buf) {
+		ref = &Ref{}
+	} else {
+		var err error
+		if ref, err = w.ag.post(ctx, w.s, w.rawSalt, w.buf); err != nil {
+			return err
+		}
 	}
 	if err := w.addRef(ctx, 0, *ref); err != nil {
 		return err
@@ -172,7 +213,7 @@ func (w *Writer) addRef(ctx context.Context, i int, ref Ref) error {
 	if w.counts[i] < w.branchingFactor {
 		return nil
 	}
-	ref2, err := w.ag.post(ctx, w.s, w.indexSalt, w.indexes[i].x)
+	ref2, err := w.postIndex(ctx, i, true)
 	if err != nil {
 		return err
 	}
@@ -181,6 +222,15 @@ func (w *Writer) addRef(ctx context.Context, i int, ref Ref) error {
 	return w.addRef(ctx, i+1, *ref2)
 }
 
+// postIndex posts the index at level i.
+// If allowHole is true, and every entry in the index is a hole, then nothing is posted, and the index is a hole.
+func (w *Writer) postIndex(ctx context.Context, i int, allowHole bool) (*Ref, error) {
+	if allowHole && allZero(w.indexes[i].x) {
+		return &Ref{}, nil
+	}
+	return w.ag.post(ctx, w.s, w.indexSalt, w.indexes[i].x)
+}
+
 func (w *Writer) finishIndexes(ctx context.Context) (*Ref, error) {
 	for i := 0; i < len(w.indexes); i++ {
 		if i == len(w.indexes)-1 {
@@ -189,11 +239,18 @@ func (w *Writer) finishIndexes(ctx context.Context) (*Ref, error) {
 			}
 			if w.counts[i] == 1 {
 				ref := w.indexes[i].Get(0)
-				return &ref, nil
+				if !ref.CID.IsZero() {
+					return &ref, nil
+				}
+				// the root is never a hole.
+				if i == 0 {
+					return w.ag.post(ctx, w.s, w.rawSalt, make([]byte, w.size))
+				}
+				return w.ag.post(ctx, w.s, w.indexSalt, newIndex(w.blockSize).x)
 			}
 		}
 		if w.counts[i] > 0 {
-			ref, err := w.ag.post(ctx, w.s, w.indexSalt, w.indexes[i].x)
+			ref, err := w.postIndex(ctx, i, i < len(w.indexes)-1)
 			if err != nil {
 				return nil, err
 			}
@@ -277,26 +334,15 @@ func (ag *Machine) Sync(ctx context.Context, dst schema.WO, src schema.RO, x Roo
 	if err := fn(r); err != nil {
 		return err
 	}
-	return ag.sync(ctx, dst, src, x.BlockSize, x.Ref, depth(x.Size, x.BlockSize))
+	return ag.sync(ctx, dst, src, x, depth(x.Size, x.BlockSize), 0, x.Ref)
 }
 
-func (ag *Machine) sync(ctx context.Context, dst schema.WO, src schema.RO, blockSize uint64, ref Ref, level int) error {
+func (ag *Machine) sync(ctx context.Context, dst schema.WO, src schema.RO, root Root, level int, offset uint64, ref Ref) error {
 	if level > 0 {
 		if err := ag.getF(ctx, src, ref, func(data []byte) error {
-			idx, err := newIndexUsing(data, int(blockSize))
-			if err != nil {
-				return err
-			}
-			for i := 0; uint64(i) < blockSize/maxRefSize; i++ {
-				ref2 := idx.Get(i)
-				if ref2.CID.IsZero() {
-					break
-				}
-				if err := ag.sync(ctx, dst, src, blockSize, ref2, level-1); err != nil {
-					return err
-				}
-			}
-			return nil
+			return forEachChild(root, level, offset, data, func(ref2 Ref, offset2 uint64) error {
+				return ag.sync(ctx, dst, src, root, level-1, offset2, ref2)
+			})
 		}); err != nil {
 			return err
 		}
@@ -2,10 +2,14 @@ package bigblob
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math/bits"
 	"math/rand"
+	"slices"
 	"testing"
 
 	"blobcache.io/blobcache/src/blobcache"
@@ -105,6 +109,38 @@ func testCreateRead(t *testing.T, size, blockSize int) {
 	streamsEqual(t, newRNG(), r)
 }
 
+func TestBlockSizeClamped(t *testing.T) {
+	for _, maxSize := range []int{1000, 192} {
+		for _, n := range []int{1 << 10, 0} {
+			t.Run(fmt.Sprintf("MaxSize-%d/BlockSize-%d", maxSize, n), func(t *testing.T) {
+				testBlockSizeClamped(t, n, maxSize)
+			})
+		}
+	}
+}
+
+func testBlockSizeClamped(t *testing.T, n, maxSize int) {
+	ctx := context.Background()
+	ag := NewMachine(WithBlockSize(n))
+	s := schema.NewMem(blobcache.HashAlgo_BLAKE3_256.HashFunc(), maxSize)
+	blockSize := 1 << (bits.Len(uint(maxSize)) - 1)
+	bf := int(branchingFactor(uint64(blockSize)))
+	size := blockSize*bf*bf + 1
+	newRNG := func() io.Reader { return io.LimitReader(rand.New(rand.NewSource(0)), int64(size)) }
+
+	root, err := ag.Create(ctx, s, nil, newRNG())
+	require.NoError(t, err)
+	require.Equal(t, uint64(blockSize), root.BlockSize)
+	streamsEqual(t, newRNG(), ag.NewReader(ctx, s, *root))
+}
+
+func TestInvalidOptions(t *testing.T) {
+	require.Panics(t, func() { WithBlockSize(1000) })
+	require.Panics(t, func() { WithBlockSize(2*RefSize - 1) })
+	require.NotPanics(t, func() { WithBlockSize(2 * RefSize) })
+	require.Panics(t, func() { WithCacheSize(0) })
+}
+
 func streamsEqual(t *testing.T, a, b io.Reader) {
 	brA := bufio.NewReader(a)
 	brB := bufio.NewReader(b)
@@ -120,3 +156,261 @@ func streamsEqual(t *testing.T, a, b io.Reader) {
 		}
 	}
 }
+
+func TestSparse(t *testing.T) {
+	const blockSize = 1 << 10
+	bf := int(branchingFactor(blockSize))
+	ctx := context.Background()
+	ag := NewMachine(WithBlockSize(blockSize))
+	data := func(n int) []byte {
+		return bytes.Repeat([]byte{1}, n)
+	}
+	zeros := func(n int) []byte {
+		return make([]byte, n)
+	}
+	tcs := []struct {
+		Name  string
+		Parts [][]byte
+		// Blocks is the number of blocks which should be stored.
+		Blocks int
+		// Data are the offsets where each data extent starts, and Holes are the offsets where each one ends.
+		Holes, Data []int64
+	}{
+		// the root is never a hole
+		{Name: "SmallZero", Parts: [][]byte{zeros(10)}, Blocks: 1, Data: []int64{0}, Holes: []int64{10}},
+		{Name: "BlockZero", Parts: [][]byte{zeros(blockSize)}, Blocks: 1, Data: []int64{0}, Holes: []int64{blockSize}},
+		{Name: "AllZero", Parts: [][]byte{zeros(blockSize * bf)}, Blocks: 1},
+		{Name: "AllZeroDeep", Parts: [][]byte{zeros(blockSize*bf + 1)}, Blocks: 1},
+		{
+			Name:   "Middle",
+			Parts:  [][]byte{data(blockSize), zeros(blockSize * bf), data(10)},
+			Blocks: 5,
+			Data:   []int64{0, blockSize * int64(bf+1)},
+			Holes:  []int64{blockSize, blockSize*int64(bf+1) + 10},
+		},
+		{
+			Name:   "Trailing",
+			Parts:  [][]byte{data(10), zeros(blockSize*3 - 10)},
+			Blocks: 2,
+			Data:   []int64{0},
+			Holes:  []int64{blockSize},
+		},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.Name, func(t *testing.T) {
+			s := schema.NewMem(blobcache.HashAlgo_BLAKE3_256.HashFunc(), blockSize)
+			expected := bytes.Join(tc.Parts, nil)
+			root, err := ag.Create(ctx, s, nil, bytes.NewReader(expected))
+			require.NoError(t, err)
+			require.Equal(t, tc.Blocks, s.Len())
+
+			// WriteZeros produces the same Root
+			w := ag.NewWriter(s, nil)
+			for _, part := range tc.Parts {
+				if allZero(part) {
+					require.NoError(t, w.WriteZeros(uint64(len(part))))
+				} else {
+					_, err := w.Write(part)
+					require.NoError(t, err)
+				}
+			}
+			root2, err := w.Finish(ctx)
+			require.NoError(t, err)
+			require.Equal(t, *root, *root2)
+
+			actual, err := io.ReadAll(ag.NewReader(ctx, s, *root))
+			require.NoError(t, err)
+			require.Equal(t, expected, actual)
+
+			dst := schema.NewMem(blobcache.HashAlgo_BLAKE3_256.HashFunc(), blockSize)
+			require.NoError(t, ag.Sync(ctx, dst, s, *root, func(*Reader) error { return nil }))
+			require.Equal(t, s.Len(), dst.Len())
+
+			r := ag.NewReader(ctx, s, *root)
+			var holes, dataStarts []int64
+			for offset := int64(0); offset < int64(root.Size); {
+				start, err := r.Seek(offset, SeekData)
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				require.NoError(t, err)
+				dataStarts = append(dataStarts, start)
+				end, err := r.Seek(start, SeekHole)
+				require.NoError(t, err)
+				holes = append(holes, end)
+				offset = end
+			}
+			require.Equal(t, tc.Data, dataStarts)
+			require.Equal(t, tc.Holes, holes)
+		})
+	}
+}
+
+func TestAppend(t *testing.T) {
+	const blockSize = 1 << 10
+	bf := int(branchingFactor(blockSize))
+	ctx := context.Background()
+	ag := NewMachine(WithBlockSize(blockSize))
+	rng := rand.New(rand.NewSource(0))
+	random := func(n int) []byte {
+		buf := make([]byte, n)
+		rng.Read(buf)
+		return buf
+	}
+	tcs := []struct {
+		Name string
+		A, B []byte
+	}{
+		{Name: "Empty", A: nil, B: random(10)},
+		{Name: "Nothing", A: random(10), B: nil},
+		{Name: "Small", A: random(10), B: random(10)},
+		{Name: "FullBlock", A: random(blockSize), B: random(10)},
+		{Name: "Spill", A: random(blockSize - 1), B: random(blockSize*3 + 7)},
+		{Name: "FullIndex", A: random(blockSize * bf), B: random(1)},
+		{Name: "Deep", A: random(blockSize*bf + 100), B: random(blockSize*bf*2 + 3)},
+		{Name: "ZeroRoot", A: make([]byte, blockSize), B: random(10)},
+		{Name: "ZeroIndex", A: make([]byte, blockSize*bf), B: random(10)},
+		{Name: "Holes", A: append(random(10), make([]byte, blockSize*bf*2)...), B: make([]byte, blockSize*3)},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.Name, func(t *testing.T) {
+			s := schema.NewMem(blobcache.HashAlgo_BLAKE3_256.HashFunc(), blockSize)
+			expected, err := ag.Create(ctx, s, nil, bytes.NewReader(append(bytes.Clone(tc.A), tc.B...)))
+			require.NoError(t, err)
+
+			s = schema.NewMem(blobcache.HashAlgo_BLAKE3_256.HashFunc(), blockSize)
+			a, err := ag.Create(ctx, s, nil, bytes.NewReader(tc.A))
+			require.NoError(t, err)
+			before := s.Len()
+			w, err := ag.NewWriterFrom(ctx, s, nil, *a)
+			require.NoError(t, err)
+			_, err = w.Write(tc.B)
+			require.NoError(t, err)
+			actual, err := w.Finish(ctx)
+			require.NoError(t, err)
+			require.Equal(t, *expected, *actual)
+			// only the new data, and the right edge of the tree are written.
+			maxNew := len(tc.B)/blockSize + 2 + 2*depth(actual.Size, blockSize)
+			require.LessOrEqual(t, s.Len()-before, maxNew)
+		})
+	}
+}
+
+func TestDiffRanges(t *testing.T) {
+	const blockSize = 1 << 10
+	bf := int(branchingFactor(blockSize))
+	ctx := context.Background()
+	ag := NewMachine(WithBlockSize(blockSize))
+	rng := rand.New(rand.NewSource(0))
+	random := func(n int) []byte {
+		buf := make([]byte, n)
+		rng.Read(buf)
+		return buf
+	}
+	modify := func(x []byte, at int) []byte {
+		x = bytes.Clone(x)
+		x[at]++
+		return x
+	}
+	deep := random(blockSize*bf*2 + 100)
+	tcs := []struct {
+		Name string
+		A, B []byte
+	}{
+		{Name: "Empty", A: nil, B: nil},
+		{Name: "Same", A: deep, B: deep},
+		{Name: "FromEmpty", A: nil, B: random(10)},
+		{Name: "Small", A: random(10), B: random(10)},
+		{Name: "OneBlock", A: deep, B: modify(deep, blockSize*bf+5)},
+		{Name: "TwoBlocks", A: deep, B: modify(modify(deep, 0), blockSize*bf*2)},
+		{Name: "Append", A: deep[:blockSize*bf], B: deep},
+		{Name: "AppendPartial", A: deep[:blockSize*bf-10], B: deep},
+		{Name: "Truncate", A: deep, B: deep[:blockSize*3+1]},
+		{Name: "Zeros", A: deep, B: append(bytes.Clone(deep[:blockSize]), make([]byte, len(deep)-blockSize)...)},
+		// the changed block in b is a copy of a block in a part of a which is the same in b.
+		{Name: "CopiedBlock", A: deep, B: copyBlock(deep, 0, blockSize*bf, blockSize)},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.Name, func(t *testing.T) {
+			s := schema.NewMem(blobcache.HashAlgo_BLAKE3_256.HashFunc(), blockSize)
+			a, err := ag.Create(ctx, s, nil, bytes.NewReader(tc.A))
+			require.NoError(t, err)
+			b, err := ag.Create(ctx, s, nil, bytes.NewReader(tc.B))
+			require.NoError(t, err)
+			delta, err := ag.DiffRanges(ctx, s, *a, *b)
+			require.NoError(t, err)
+
+			// compare the blobs one block at a time.
+			var expected []Range
+			for i := 0; i < max(len(tc.A), len(tc.B)); i += blockSize {
+				if bytes.Equal(blockAt(tc.A, i, blockSize), blockAt(tc.B, i, blockSize)) {
+					continue
+				}
+				end := uint64(min(i+blockSize, max(len(tc.A), len(tc.B))))
+				if n := len(expected); n > 0 && expected[n-1].End == uint64(i) {
+					expected[n-1].End = end
+				} else {
+					expected = append(expected, Range{Start: uint64(i), End: end})
+				}
+			}
+			require.Equal(t, expected, delta.Ranges)
+			require.ElementsMatch(t, setMinus(blockCIDs(t, ag, s, *b), blockCIDs(t, ag, s, *a)), delta.New)
+		})
+	}
+}
+
+func TestDiffRangesBlockSize(t *testing.T) {
+	ctx := context.Background()
+	s := schema.NewMem(blobcache.HashAlgo_BLAKE3_256.HashFunc(), 1<<12)
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1<<10)
+	a, err := NewMachine(WithBlockSize(1<<10)).Create(ctx, s, nil, bytes.NewReader(data))
+	require.NoError(t, err)
+	ag := NewMachine(WithBlockSize(1 << 12))
+	b, err := ag.Create(ctx, s, nil, bytes.NewReader(data))
+	require.NoError(t, err)
+	delta, err := ag.DiffRanges(ctx, s, *a, *b)
+	require.NoError(t, err)
+	require.Equal(t, []Range{{Start: 0, End: uint64(len(data))}}, delta.Ranges)
+	require.ElementsMatch(t, blockCIDs(t, ag, s, *b), delta.New)
+}
+
+// copyBlock returns a copy of x, with the block at dst replaced by the block at src.
+func copyBlock(x []byte, src, dst, blockSize int) []byte {
+	x = bytes.Clone(x)
+	copy(x[dst:dst+blockSize], x[src:src+blockSize])
+	return x
+}
+
+func blockAt(x []byte, i, blockSize int) []byte {
+	if i >= len(x) {
+		return nil
+	}
+	return x[i:min(i+blockSize, len(x))]
+}
+
+// blockCIDs returns the CIDs of all the blocks stored for root, excluding holes.
+func blockCIDs(t testing.TB, ag *Machine, s schema.RO, root Root) []blobcache.CID {
+	seen := map[blobcache.CID]struct{}{}
+	var ret []blobcache.CID
+	err := ag.TraverseBlocks(context.Background(), s, root, func(ctx context.Context, id blobcache.CID) (bool, error) {
+		_, exists := seen[id]
+		return !exists, nil
+	}, func(ctx context.Context, b Block) error {
+		if _, exists := seen[b.Ref.CID]; !exists && !b.Ref.CID.IsZero() {
+			seen[b.Ref.CID] = struct{}{}
+			ret = append(ret, b.Ref.CID)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	return ret
+}
+
+func setMinus(xs, ys []blobcache.CID) (ret []blobcache.CID) {
+	for _, x := range xs {
+		if !slices.Contains(ys, x) {
+			ret = append(ret, x)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,252 @@
+package bigblob
+
+import (
+	"context"
+
+	"blobcache.io/blobcache/src/bcsdk"
+	"blobcache.io/blobcache/src/blobcache"
+)
+
+// Range is the range of bytes [Start, End)
+type Range struct {
+	Start, End uint64
+}
+
+// Delta is returned by DiffRanges
+type Delta struct {
+	// Ranges are the byte ranges which differ between the blobs, in order, and not overlapping.
+	// Ranges are aligned to the block size, except where they end at the end of the longer blob.
+	Ranges []Range
+	// New is the CIDs of the blocks in b which are not in a.
+	// These are the blocks which would be transferred when syncing b to a store containing a.
+	New []blobcache.CID
+}
+
+// DiffRanges compares the blobs a and b, which must both be in s.
+// The index trees of the two blobs are compared, and subtrees with identical Refs are skipped without being read.
+// Data blocks are never read, so the cost of finding the Ranges is proportional to the amount of data which changed.
+// A block in b can also appear in a part of a which was skipped, so if there are blocks which could be new,
+// then the index blocks of the skipped parts of a are read to find them.
+//
+// If a and b have different block sizes, then nothing can be shared, and the whole of b is reported as different.
+func (ag *Machine) DiffRanges(ctx context.Context, s bcsdk.RO, a, b Root) (*Delta, error) {
+	d := differ{
+		ag:    ag,
+		s:     s,
+		a:     a,
+		b:     b,
+		seenA: map[blobcache.CID]struct{}{},
+		seenB: map[blobcache.CID]struct{}{},
+	}
+	if a.BlockSize != b.BlockSize {
+		if err := ag.Traverse(ctx, s, nil, b, Traverser{
+			Enter: func(ctx context.Context, id blobcache.CID) (bool, error) {
+				_, exists := d.seenB[id]
+				return !exists, nil
+			},
+			Exit: func(ctx context.Context, level int, ref Ref) error {
+				d.addB(ref)
+				return nil
+			},
+		}); err != nil {
+			return nil, err
+		}
+		d.addRange(0, max(a.Size, b.Size))
+	} else {
+		level := max(depth(a.Size, a.BlockSize), depth(b.Size, b.BlockSize))
+		x := diffNode{ref: &a.Ref, level: depth(a.Size, a.BlockSize)}
+		y := diffNode{ref: &b.Ref, level: depth(b.Size, b.BlockSize)}
+		if err := d.diff(ctx, level, 0, x, y); err != nil {
+			return nil, err
+		}
+	}
+	var delta Delta
+	delta.Ranges = d.ranges
+	candidates := map[blobcache.CID]struct{}{}
+	for _, cid := range d.orderB {
+		if _, exists := d.seenA[cid]; !exists {
+			candidates[cid] = struct{}{}
+		}
+	}
+	for _, n := range d.skippedA {
+		if len(candidates) == 0 {
+			break
+		}
+		if err := d.removeFound(ctx, n, candidates); err != nil {
+			return nil, err
+		}
+	}
+	for _, cid := range d.orderB {
+		if _, exists := candidates[cid]; exists {
+			delta.New = append(delta.New, cid)
+		}
+	}
+	return &delta, nil
+}
+
+// diffNode is a node in one of the trees being compared.
+// If ref is nil, the node is beyond the end of the blob.
+// level is the level of ref in its tree, which can be less than the level it is being compared at,
+// when the other tree is deeper.
+type diffNode struct {
+	ref   *Ref
+	level int
+}
+
+type differ struct {
+	ag           *Machine
+	s            bcsdk.RO
+	a, b         Root
+	seenA, seenB map[blobcache.CID]struct{}
+	orderB       []blobcache.CID
+	ranges       []Range
+	// skippedA are the subtrees of a which were identical in b, and not read.
+	skippedA []skipped
+}
+
+type skipped struct {
+	ref    Ref
+	level  int
+	offset uint64
+}
+
+// diff compares the nodes x from a, and y from b, which both begin at offset, at level.
+func (d *differ) diff(ctx context.Context, level int, offset uint64, x, y diffNode) error {
+	if x.ref != nil && y.ref != nil && x.level == y.level && x.ref.Equals(*y.ref) {
+		if !x.ref.CID.IsZero() {
+			d.skippedA = append(d.skippedA, skipped{ref: *x.ref, level: x.level, offset: offset})
+		}
+		return nil
+	}
+	if level == 0 {
+		if x.ref != nil {
+			d.addA(*x.ref)
+		}
+		if y.ref != nil {
+			d.addB(*y.ref)
+		}
+		d.addRange(offset, min(offset+d.a.BlockSize, max(d.a.Size, d.b.Size)))
+		return nil
+	}
+	xs, err := d.children(ctx, d.a, level, offset, x, d.addA)
+	if err != nil {
+		return err
+	}
+	ys, err := d.children(ctx, d.b, level, offset, y, d.addB)
+	if err != nil {
+		return err
+	}
+	span := d.a.BlockSize * pow(branchingFactor(d.a.BlockSize), uint64(level-1))
+	for i := range xs {
+		if xs[i].ref == nil && ys[i].ref == nil {
+			break
+		}
+		if err := d.diff(ctx, level-1, offset+uint64(i)*span, xs[i], ys[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// children returns the children of n, which is being compared at level, in the blob root.
+// If n is at a lower level than it is being compared at, then it is the first child of a virtual node.
+func (d *differ) children(ctx context.Context, root Root, level int, offset uint64, n diffNode, add func(Ref)) ([]diffNode, error) {
+	bf := branchingFactor(root.BlockSize)
+	ret := make([]diffNode, bf)
+	switch {
+	case n.ref == nil:
+	case n.level < level:
+		ret[0] = n
+	case n.ref.CID.IsZero():
+		// a hole, all the children are holes
+		span := root.BlockSize * pow(bf, uint64(level-1))
+		for i := range ret {
+			if offset+uint64(i)*span >= root.Size {
+				break
+			}
+			ret[i] = diffNode{ref: &Ref{}, level: level - 1}
+		}
+	default:
+		add(*n.ref)
+		if err := d.ag.getF(ctx, d.s, *n.ref, func(data []byte) error {
+			idx, err := newIndexUsing(data, int(root.BlockSize))
+			if err != nil {
+				return err
+			}
+			span := root.BlockSize * pow(bf, uint64(level-1))
+			for i := range ret {
+				if offset+uint64(i)*span >= root.Size {
+					break
+				}
+				ref := idx.Get(i)
+				ret[i] = diffNode{ref: &ref, level: level - 1}
+			}
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// removeFound removes the CIDs of the blocks in the skipped subtree n from candidates.
+// Only index blocks are read, the CIDs of data blocks are found in their parents.
+func (d *differ) removeFound(ctx context.Context, n skipped, candidates map[blobcache.CID]struct{}) error {
+	delete(candidates, n.ref.CID)
+	if n.level == 0 || n.ref.CID.IsZero() || len(candidates) == 0 {
+		return nil
+	}
+	bf := branchingFactor(d.a.BlockSize)
+	span := d.a.BlockSize * pow(bf, uint64(n.level-1))
+	var children []skipped
+	if err := d.ag.getF(ctx, d.s, n.ref, func(data []byte) error {
+		idx, err := newIndexUsing(data, int(d.a.BlockSize))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < int(bf); i++ {
+			offset := n.offset + uint64(i)*span
+			if offset >= d.a.Size {
+				break
+			}
+			children = append(children, skipped{ref: idx.Get(i), level: n.level - 1, offset: offset})
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	for _, child := range children {
+		if err := d.removeFound(ctx, child, candidates); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *differ) addA(ref Ref) {
+	if !ref.CID.IsZero() {
+		d.seenA[ref.CID] = struct{}{}
+	}
+}
+
+func (d *differ) addB(ref Ref) {
+	if ref.CID.IsZero() {
+		return
+	}
+	if _, exists := d.seenB[ref.CID]; !exists {
+		d.seenB[ref.CID] = struct{}{}
+		d.orderB = append(d.orderB, ref.CID)
+	}
+}
+
+// addRange adds [start, end) to the changed ranges, merging it with the previous range if they are adjacent.
+func (d *differ) addRange(start, end uint64) {
+	if start >= end {
+		return
+	}
+	if n := len(d.ranges); n > 0 && d.ranges[n-1].End == start {
+		d.ranges[n-1].End = end
+		return
+	}
+	d.ranges = append(d.ranges, Range{Start: start, End: end})
+}
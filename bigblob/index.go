@@ -1,6 +1,9 @@
 package bigblob
 
-import "fmt"
+import (
+	"bytes"
+	"fmt"
+)
 
 // maxRefSize is the size of a slot in an index
 const maxRefSize = RefSize
@@ -46,3 +49,41 @@ func (idx Index) Clear() {
 		idx.x[i] = 0
 	}
 }
+
+// forEachChild calls fn with each child of the index in data, along with the child's offset in the blob.
+// The index is at level, and offset in the blob at root.
+// Holes are skipped.
+func forEachChild(root Root, level int, offset uint64, data []byte, fn func(ref Ref, offset uint64) error) error {
+	idx, err := newIndexUsing(data, int(root.BlockSize))
+	if err != nil {
+		return err
+	}
+	span := root.BlockSize * pow(branchingFactor(root.BlockSize), uint64(level-1))
+	for i := 0; i < idx.Len(); i++ {
+		offset2 := offset + uint64(i)*span
+		if offset2 >= root.Size {
+			break
+		}
+		ref := idx.Get(i)
+		if ref.CID.IsZero() {
+			continue
+		}
+		if err := fn(ref, offset2); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var zeros = make([]byte, 1<<16)
+
+func allZero(x []byte) bool {
+	for len(x) > 0 {
+		n := min(len(x), len(zeros))
+		if !bytes.Equal(x[:n], zeros[:n]) {
+			return false
+		}
+		x = x[n:]
+	}
+	return true
+}
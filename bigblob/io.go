@@ -12,6 +12,16 @@ var (
 	_ io.ReaderAt   = &Reader{}
 )
 
+const (
+	// SeekData can be passed to Reader.Seek as whence, to seek to the next data at or after offset.
+	// It has the same value as SEEK_DATA on Linux.
+	SeekData = 3
+	// SeekHole can be passed to Reader.Seek as whence, to seek to the next hole at or after offset.
+	// There is always an implicit hole at the end of the blob.
+	// It has the same value as SEEK_HOLE on Linux.
+	SeekHole = 4
+)
+
 type Reader struct {
 	o      *Machine
 	ctx    context.Context
@@ -47,8 +57,59 @@ func (r *Reader) Seek(offset int64, whence int) (int64, error) {
 		r.offset += offset
 	case io.SeekEnd:
 		r.offset = int64(r.root.Size) + offset
+	case SeekData, SeekHole:
+		if offset < 0 || uint64(offset) >= r.root.Size {
+			return r.offset, io.EOF
+		}
+		pos, found, err := r.o.seekExtent(r.ctx, r.store, r.root, depth(r.root.Size, r.root.BlockSize), 0, r.root.Ref, uint64(offset), whence == SeekData)
+		if err != nil {
+			return r.offset, err
+		}
+		switch {
+		case found:
+			r.offset = int64(pos)
+		case whence == SeekHole:
+			r.offset = int64(r.root.Size)
+		default:
+			return r.offset, io.EOF
+		}
 	default:
 		panic("invalid whence")
 	}
 	return int64(r.offset), nil
 }
+
+// seekExtent finds the first position at or after offset, which is in data if wantData is true, or in a hole otherwise.
+// x is the block at level, which begins at nodeOffset in the blob at root.
+func (ag *Machine) seekExtent(ctx context.Context, s bcsdk.RO, root Root, level int, nodeOffset uint64, x Ref, offset uint64, wantData bool) (uint64, bool, error) {
+	span := root.BlockSize * pow(branchingFactor(root.BlockSize), uint64(level))
+	if nodeOffset+span <= offset {
+		return 0, false, nil
+	}
+	switch {
+	case x.CID.IsZero():
+		return max(offset, nodeOffset), !wantData, nil
+	case level == 0:
+		return max(offset, nodeOffset), wantData, nil
+	}
+	var pos uint64
+	var found bool
+	err := ag.getF(ctx, s, x, func(data []byte) error {
+		idx, err := newIndexUsing(data, int(root.BlockSize))
+		if err != nil {
+			return err
+		}
+		childSpan := span / branchingFactor(root.BlockSize)
+		for i := 0; i < idx.Len() && !found; i++ {
+			childOffset := nodeOffset + uint64(i)*childSpan
+			if childOffset >= root.Size {
+				break
+			}
+			if pos, found, err = ag.seekExtent(ctx, s, root, level-1, childOffset, idx.Get(i), offset, wantData); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return pos, found, err
+}
@@ -2,6 +2,7 @@ package bigblob
 
 import (
 	"context"
+	"fmt"
 	"sync"
 
 	"blobcache.io/blobcache/src/blobcache"
@@ -10,18 +11,25 @@ import (
 
 type Option func(*Machine)
 
+// WithCacheSize sets the number of blocks which the Machine will cache.
+// If n < 1 then WithCacheSize panics.
+// The default is 64.
 func WithCacheSize(n int) Option {
+	if n < 1 {
+		panic(fmt.Sprintf("cache size cannot be < 1: %d", n))
+	}
 	return func(ag *Machine) {
 		ag.cacheSize = n
 	}
 }
 
 // WithBlockSize sets the block size used when writing files.
-// If n < 0 then WithBlockSize panics
-// If n == 0 then the store's MaxBlobSize will be used as a default.
+// If n == 0 then the store's MaxSize will be used as a default.
+// Otherwise n must be a power of two, and at least 2*RefSize, or WithBlockSize panics.
+// If the block size is larger than the MaxSize of the store being written to, the largest power of two which fits in the store is used instead.
 func WithBlockSize(n int) Option {
-	if n < 0 {
-		panic(n)
+	if n < 0 || n > 0 && (n < 2*maxRefSize || n&(n-1) != 0) {
+		panic(fmt.Sprintf("block size must be 0, or a power of two >= %d: %d", 2*maxRefSize, n))
 	}
 	return func(ag *Machine) {
 		ag.blockSize = n
@@ -19,10 +19,10 @@ func (ag *Machine) Traverse(ctx context.Context, s bcsdk.RO, sem *semaphore.Weig
 	if root.BlockSize == 0 {
 		return fmt.Errorf("block size cannot be zero")
 	}
-	return ag.traverse(ctx, s, sem, root.BlockSize, depth(root.Size, root.BlockSize), root.Ref, tr)
+	return ag.traverse(ctx, s, sem, root, depth(root.Size, root.BlockSize), 0, root.Ref, tr)
 }
 
-func (ag *Machine) traverse(ctx context.Context, s bcsdk.RO, sem *semaphore.Weighted, blockSize uint64, level int, x Ref, tr Traverser) error {
+func (ag *Machine) traverse(ctx context.Context, s bcsdk.RO, sem *semaphore.Weighted, root Root, level int, offset uint64, x Ref, tr Traverser) error {
 	if yes, err := tr.Enter(ctx, x.CID); err != nil {
 		return err
 	} else if !yes {
@@ -30,23 +30,50 @@ func (ag *Machine) traverse(ctx context.Context, s bcsdk.RO, sem *semaphore.Weig
 	}
 	if level > 0 {
 		if err := ag.getF(ctx, s, x, func(data []byte) error {
-			idx, err := newIndexUsing(data, int(blockSize))
-			if err != nil {
-				return err
-			}
-			for i := 0; uint64(i) < blockSize/maxRefSize; i++ {
-				ref2 := idx.Get(i)
-				if ref2.CID.IsZero() {
-					break
-				}
-				if err := ag.traverse(ctx, s, sem, blockSize, level-1, ref2, tr); err != nil {
-					return err
-				}
-			}
-			return nil
+			return forEachChild(root, level, offset, data, func(ref2 Ref, offset2 uint64) error {
+				return ag.traverse(ctx, s, sem, root, level-1, offset2, ref2, tr)
+			})
 		}); err != nil {
 			return err
 		}
 	}
 	return tr.Exit(ctx, level, x)
 }
+
+// Block is a single block in a blob.
+type Block struct {
+	Ref Ref
+	// Level is 0 for data blocks, and greater than 0 for index blocks.
+	Level int
+	// Size is the length of the block's ciphertext in the store.
+	Size int
+}
+
+// TraverseBlocks calls exit with every block in the blob at root, index blocks after their children.
+// If enter returns false for a block, it is skipped along with its children.
+// Data blocks are never read from s, only index blocks.
+func (ag *Machine) TraverseBlocks(ctx context.Context, s bcsdk.RO, root Root, enter func(ctx context.Context, id blobcache.CID) (bool, error), exit func(ctx context.Context, b Block) error) error {
+	if root.BlockSize == 0 {
+		return fmt.Errorf("block size cannot be zero")
+	}
+	return ag.traverseBlocks(ctx, s, root, depth(root.Size, root.BlockSize), 0, root.Ref, enter, exit)
+}
+
+func (ag *Machine) traverseBlocks(ctx context.Context, s bcsdk.RO, root Root, level int, offset uint64, x Ref, enter func(context.Context, blobcache.CID) (bool, error), exit func(context.Context, Block) error) error {
+	if yes, err := enter(ctx, x.CID); err != nil {
+		return err
+	} else if !yes {
+		return nil
+	}
+	if level == 0 {
+		return exit(ctx, Block{Ref: x, Size: int(min(root.BlockSize, root.Size-offset))})
+	}
+	if err := ag.getF(ctx, s, x, func(data []byte) error {
+		return forEachChild(root, level, offset, data, func(ref2 Ref, offset2 uint64) error {
+			return ag.traverseBlocks(ctx, s, root, level-1, offset2, ref2, enter, exit)
+		})
+	}); err != nil {
+		return err
+	}
+	return exit(ctx, Block{Ref: x, Level: level, Size: int(root.BlockSize)})
+}
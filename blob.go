@@ -11,11 +11,35 @@ import (
 
 type Reader = bigblob.Reader
 
+const (
+	// SeekData can be passed to Reader.Seek, to seek to the next data which is not in a hole.
+	SeekData = bigblob.SeekData
+	// SeekHole can be passed to Reader.Seek, to seek to the next hole.
+	SeekHole = bigblob.SeekHole
+)
+
 // PostBlob creates a new blob with data from r, and returns a Ref to it.
 func (ag *Machine) PostBlob(ctx context.Context, s schema.WO, r io.Reader) (*Ref, error) {
 	return ag.PostTyped(ctx, s, TypeBlob, r)
 }
 
+// Append appends the data from r to the blob at x, and returns a Ref to the longer blob.
+// Only the end of the blob is rewritten, so the cost of Append is proportional to the amount of data appended.
+// The result is the same as posting all of the data at once.
+func (ag *Machine) Append(ctx context.Context, s schema.RW, x Ref, r io.Reader) (*Ref, error) {
+	if x.Type != TypeBlob {
+		return nil, ErrRefType{Have: x.Type, Want: TypeBlob}
+	}
+	tw, err := ag.NewTypedWriterFrom(ctx, s, x)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(tw, r); err != nil {
+		return nil, err
+	}
+	return tw.Finish(ctx)
+}
+
 // GetBlob returns an io.ReadSeeker for accessing data from the blob at x
 func (ag *Machine) GetBlob(ctx context.Context, s schema.RO, x Ref) (*Reader, error) {
 	return ag.NewBlobReader(ctx, s, x)
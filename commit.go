@@ -0,0 +1,201 @@
+package glfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"time"
+
+	"blobcache.io/blobcache/src/blobcache"
+	"blobcache.io/blobcache/src/schema"
+	"blobcache.io/glfs/bigblob"
+	"go.brendoncarroll.net/exp/heaps"
+)
+
+// MaxCommitSize is the maximum size of a serialized Commit
+const MaxCommitSize = 1 << 20
+
+// Commit is a snapshot of a tree, and the history leading up to it.
+type Commit struct {
+	// Root is the tree which was snapshotted.
+	Root Ref `json:"root"`
+	// Parents are the commits which this commit was derived from.
+	// The first commit in a history has no parents, and a merge has more than one.
+	Parents []Ref `json:"parents,omitempty"`
+
+	Author  string    `json:"author,omitempty"`
+	Message string    `json:"message,omitempty"`
+	Time    time.Time `json:"time"`
+	// Metadata is free-form, and not interpretted by glfs.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+func (c *Commit) Validate() error {
+	if c.Root.Type != TypeTree {
+		return fmt.Errorf("commit root must be a tree, have %s", c.Root.Type)
+	}
+	for _, parent := range c.Parents {
+		if parent.Type != TypeCommit {
+			return fmt.Errorf("commit parents must be commits, have %s", parent.Type)
+		}
+	}
+	return nil
+}
+
+// refs returns every Ref directly referenced by the commit.
+func (c *Commit) refs() []Ref {
+	return append([]Ref{c.Root}, c.Parents...)
+}
+
+// PostCommit posts c to s, and returns a Ref to it.
+// The root and parents of c must already exist in s.
+func (ag *Machine) PostCommit(ctx context.Context, s schema.WO, c Commit) (*Ref, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	for _, ref := range c.refs() {
+		if yes, err := bigblob.ExistsUnit(ctx, s, ref.CID); err != nil {
+			return nil, err
+		} else if !yes {
+			return nil, fmt.Errorf("posting commit would violate referential integrity, missing %v", ref)
+		}
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return ag.PostTyped(ctx, s, TypeCommit, bytes.NewReader(data))
+}
+
+// GetCommit retrieves the commit in s at ref.
+// If ref.Type != TypeCommit ErrRefType is returned.
+func (ag *Machine) GetCommit(ctx context.Context, s schema.RO, ref Ref) (*Commit, error) {
+	r, err := ag.GetTyped(ctx, s, TypeCommit, ref)
+	if err != nil {
+		return nil, err
+	}
+	return readCommit(r)
+}
+
+func readCommit(r io.Reader) (*Commit, error) {
+	data, err := readAtMost(r, MaxCommitSize)
+	if err != nil {
+		return nil, err
+	}
+	var c Commit
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// LogEntry is a commit yielded by Log
+type LogEntry struct {
+	Ref    Ref
+	Commit Commit
+}
+
+// Log returns an iterator over head and all of its ancestors.
+// Commits are yielded newest first, by Commit.Time, and each commit is yielded once.
+func (ag *Machine) Log(ctx context.Context, s schema.RO, head Ref) iter.Seq2[LogEntry, error] {
+	return func(yield func(LogEntry, error) bool) {
+		q := heaps.New(func(a, b LogEntry) bool {
+			return a.Commit.Time.After(b.Commit.Time)
+		})
+		seen := map[blobcache.CID]struct{}{}
+		push := func(ref Ref) error {
+			if _, exists := seen[ref.CID]; exists {
+				return nil
+			}
+			seen[ref.CID] = struct{}{}
+			c, err := ag.GetCommit(ctx, s, ref)
+			if err != nil {
+				return err
+			}
+			q.Push(LogEntry{Ref: ref, Commit: *c})
+			return nil
+		}
+		if err := push(head); err != nil {
+			yield(LogEntry{}, err)
+			return
+		}
+		for q.Len() > 0 {
+			ent := q.Pop()
+			if !yield(ent, nil) {
+				return
+			}
+			for _, parent := range ent.Commit.Parents {
+				if err := push(parent); err != nil {
+					yield(LogEntry{}, err)
+					return
+				}
+			}
+		}
+	}
+}
+
+// MergeBase returns the best common ancestor of a and b.
+// That is a commit which is an ancestor of both a and b, and is not an ancestor of any other common ancestor.
+// A commit is considered an ancestor of itself.
+// The base is found from the commit graph alone, Commit.Time is not used.
+// If there is more than one best common ancestor, which can happen after criss-cross merges, the one with the lowest CID is returned.
+// If a and b have no common ancestor, then (nil, nil) is returned.
+func (ag *Machine) MergeBase(ctx context.Context, s schema.RO, a, b Ref) (*Ref, error) {
+	ancestorsA, err := ag.ancestors(ctx, s, a)
+	if err != nil {
+		return nil, err
+	}
+	ancestorsB, err := ag.ancestors(ctx, s, b)
+	if err != nil {
+		return nil, err
+	}
+	common := map[blobcache.CID]LogEntry{}
+	for cid, ent := range ancestorsB {
+		if _, exists := ancestorsA[cid]; exists {
+			common[cid] = ent
+		}
+	}
+	// a common ancestor which is an ancestor of another common ancestor is not the best.
+	redundant := map[blobcache.CID]struct{}{}
+	var stack []Ref
+	for _, ent := range common {
+		stack = append(stack, ent.Commit.Parents...)
+	}
+	for len(stack) > 0 {
+		ref := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, exists := redundant[ref.CID]; exists {
+			continue
+		}
+		redundant[ref.CID] = struct{}{}
+		stack = append(stack, ancestorsB[ref.CID].Commit.Parents...)
+	}
+	var best *Ref
+	for cid, ent := range common {
+		if _, exists := redundant[cid]; exists {
+			continue
+		}
+		if best == nil || bytes.Compare(cid[:], best.CID[:]) < 0 {
+			best = &ent.Ref
+		}
+	}
+	return best, nil
+}
+
+// ancestors returns head and all of its ancestors, by CID.
+func (ag *Machine) ancestors(ctx context.Context, s schema.RO, head Ref) (map[blobcache.CID]LogEntry, error) {
+	ret := map[blobcache.CID]LogEntry{}
+	for ent, err := range ag.Log(ctx, s, head) {
+		if err != nil {
+			return nil, err
+		}
+		ret[ent.Ref.CID] = ent
+	}
+	return ret, nil
+}
@@ -0,0 +1,163 @@
+package glfs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"blobcache.io/blobcache/src/blobcache"
+	"blobcache.io/glfs/bigblob"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommitLog(t *testing.T) {
+	ctx := context.TODO()
+	s := newStore(t)
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	commit := func(msg string, i int, parents ...Ref) Ref {
+		root := mustPostTree(t, s, map[string]Ref{
+			"msg.txt": mustPostBlob(t, s, []byte(msg)),
+		})
+		ref, err := PostCommit(ctx, s, Commit{
+			Root:    root,
+			Parents: parents,
+			Message: msg,
+			Time:    t0.Add(time.Duration(i) * time.Hour),
+		})
+		require.NoError(t, err)
+		return *ref
+	}
+	c0 := commit("initial", 0)
+	c1 := commit("base", 1, c0)
+	a := commit("a", 2, c1)
+	b1 := commit("b1", 3, c1)
+	b2 := commit("b2", 4, b1)
+	m := commit("merge", 5, a, b2)
+
+	c, err := GetCommit(ctx, s, m)
+	require.NoError(t, err)
+	require.Equal(t, "merge", c.Message)
+	require.Equal(t, []Ref{a, b2}, c.Parents)
+
+	var msgs []string
+	for ent, err := range defaultOp.Log(ctx, s, m) {
+		require.NoError(t, err)
+		msgs = append(msgs, ent.Commit.Message)
+	}
+	require.Equal(t, []string{"merge", "b2", "b1", "a", "base", "initial"}, msgs)
+
+	base, err := defaultOp.MergeBase(ctx, s, a, b2)
+	require.NoError(t, err)
+	require.Equal(t, c1, *base)
+	base, err = defaultOp.MergeBase(ctx, s, m, b1)
+	require.NoError(t, err)
+	require.Equal(t, b1, *base)
+
+	// Sync follows roots and parents
+	dst := newStore(t)
+	require.NoError(t, Sync(ctx, dst, s, m))
+	var count int
+	require.NoError(t, WalkRefs(ctx, dst, m, func(ref Ref) error {
+		count++
+		return nil
+	}))
+	require.Greater(t, count, 6)
+
+	// Traverse visits every block
+	seen := map[blobcache.CID]bool{}
+	require.NoError(t, defaultOp.Traverse(ctx, dst, nil, m, Traverser{
+		Enter: func(ctx context.Context, id blobcache.CID) (bool, error) {
+			return !seen[id], nil
+		},
+		Exit: func(ctx context.Context, ty Type, level int, ref bigblob.Ref) error {
+			seen[ref.CID] = true
+			return nil
+		},
+	}))
+	require.Equal(t, dst.Len(), len(seen))
+}
+
+func TestMergeBaseGraph(t *testing.T) {
+	ctx := context.TODO()
+	s := newStore(t)
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	commit := func(msg string, hours int, parents ...Ref) Ref {
+		ref, err := PostCommit(ctx, s, Commit{
+			Root:    mustPostTree(t, s, map[string]Ref{"msg.txt": mustPostBlob(t, s, []byte(msg))}),
+			Parents: parents,
+			Message: msg,
+			Time:    t0.Add(time.Duration(hours) * time.Hour),
+		})
+		require.NoError(t, err)
+		return *ref
+	}
+	// c1's clock was ahead, so it is newer than its children.
+	c0 := commit("initial", 0)
+	c1 := commit("base", 100, c0)
+	a := commit("a", 2, c1)
+	b := commit("b", 3, c1)
+	base, err := defaultOp.MergeBase(ctx, s, a, b)
+	require.NoError(t, err)
+	require.Equal(t, c1, *base)
+	// an unrelated commit on one side, with a newer time, is not the base.
+	x := commit("x", 200, c0)
+	m := commit("merge-x", 4, a, x)
+	base, err = defaultOp.MergeBase(ctx, s, m, b)
+	require.NoError(t, err)
+	require.Equal(t, c1, *base)
+
+	// c0's clock was ahead of c1's, and c0 is reachable from d without going through c1.
+	c0 = commit("initial-skewed", 50)
+	c1 = commit("base-skewed", 1, c0)
+	a = commit("a-skewed", 2, c1)
+	y := commit("y", 3, c1)
+	z := commit("z", 4, c0)
+	d := commit("d", 5, y, z)
+	base, err = defaultOp.MergeBase(ctx, s, a, d)
+	require.NoError(t, err)
+	require.Equal(t, c1, *base)
+
+	// criss-cross: m1 and m2 both merge a and b, so both a and b are best common ancestors.
+	m1 := commit("m1", 5, a, b)
+	m2 := commit("m2", 6, b, a)
+	base, err = defaultOp.MergeBase(ctx, s, m1, m2)
+	require.NoError(t, err)
+	require.Contains(t, []Ref{a, b}, *base)
+	base2, err := defaultOp.MergeBase(ctx, s, m2, m1)
+	require.NoError(t, err)
+	require.Equal(t, *base, *base2)
+
+	// unrelated histories have no base.
+	base, err = defaultOp.MergeBase(ctx, s, a, commit("other", 7))
+	require.NoError(t, err)
+	require.Nil(t, base)
+}
+
+func TestWalkRefsDiamonds(t *testing.T) {
+	ctx := context.TODO()
+	s := newStore(t)
+	root := mustPostTree(t, s, map[string]Ref{})
+	head, err := PostCommit(ctx, s, Commit{Root: root})
+	require.NoError(t, err)
+	// each merge doubles the number of paths to the first commit.
+	const n = 30
+	for i := 0; i < n; i++ {
+		left, err := PostCommit(ctx, s, Commit{Root: root, Parents: []Ref{*head}, Message: "left"})
+		require.NoError(t, err)
+		right, err := PostCommit(ctx, s, Commit{Root: root, Parents: []Ref{*head}, Message: "right"})
+		require.NoError(t, err)
+		head, err = PostCommit(ctx, s, Commit{Root: root, Parents: []Ref{*left, *right}})
+		require.NoError(t, err)
+	}
+	commits := map[blobcache.CID]int{}
+	require.NoError(t, WalkRefs(ctx, s, *head, func(ref Ref) error {
+		if ref.Type == TypeCommit {
+			commits[ref.CID]++
+		}
+		return nil
+	}))
+	require.Len(t, commits, 3*n+1)
+	for _, count := range commits {
+		require.Equal(t, 1, count)
+	}
+}
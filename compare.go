@@ -18,6 +18,7 @@ type Diff struct {
 
 // Compare compares left and right and returns a diff.
 // Left and right must both point only to data in s.
+// To list the paths which differ between left and right, use Machine.Diff instead.
 func (ag *Machine) Compare(ctx context.Context, dst schema.WO, src schema.RO, left, right Ref) (*Diff, error) {
 	if left.Type != right.Type {
 		return &Diff{
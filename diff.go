@@ -0,0 +1,121 @@
+package glfs
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"path"
+	"strings"
+
+	"blobcache.io/blobcache/src/schema"
+)
+
+// ChangeKind is the kind of a Change
+type ChangeKind string
+
+const (
+	// ChangeAdded means the path exists only in the right tree.
+	ChangeAdded = ChangeKind("added")
+	// ChangeRemoved means the path exists only in the left tree.
+	ChangeRemoved = ChangeKind("removed")
+	// ChangeModified means the path refers to different data in each tree.
+	ChangeModified = ChangeKind("modified")
+	// ChangeModeChanged means the path refers to the same data in each tree, but with a different mode.
+	ChangeModeChanged = ChangeKind("mode")
+)
+
+// Change is a difference at a single path between two trees.
+type Change struct {
+	Path string
+	Kind ChangeKind
+	// Old is the entry in the left tree, it is nil if the path was added.
+	Old *TreeEntry
+	// New is the entry in the right tree, it is nil if the path was removed.
+	New *TreeEntry
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s %s", c.Kind, c.Path)
+}
+
+// Diff returns an iterator over the changes from left to right, in lexigraphical order by path.
+// left is read from ls, and right is read from rs, which may be different stores.
+//
+// Subtrees with equal Refs are skipped without being read.
+// When a whole tree is added or removed, a single Change is yielded for the tree, and its children are not visited.
+// When a tree is replaced by a blob, or a blob by a tree, it is reported as ChangeModified.
+func (ag *Machine) Diff(ctx context.Context, ls schema.RO, left Ref, rs schema.RO, right Ref) iter.Seq2[Change, error] {
+	return func(yield func(Change, error) bool) {
+		lEnt := TreeEntry{Ref: left, FileMode: getFileMode(left)}
+		rEnt := TreeEntry{Ref: right, FileMode: getFileMode(right)}
+		ag.diffEntry(ctx, ls, rs, "", lEnt, rEnt, yield)
+	}
+}
+
+// diffEntry yields the changes between two entries at the same path, and returns false if iteration should stop.
+func (ag *Machine) diffEntry(ctx context.Context, ls, rs schema.RO, p string, left, right TreeEntry, yield func(Change, error) bool) bool {
+	switch {
+	case left.Ref.Equals(right.Ref):
+		if left.FileMode == right.FileMode {
+			return true
+		}
+		return yield(Change{Path: p, Kind: ChangeModeChanged, Old: &left, New: &right}, nil)
+	case left.Ref.Type == TypeTree && right.Ref.Type == TypeTree:
+		if left.FileMode != right.FileMode {
+			if !yield(Change{Path: p, Kind: ChangeModeChanged, Old: &left, New: &right}, nil) {
+				return false
+			}
+		}
+		return ag.diffTrees(ctx, ls, rs, p, left.Ref, right.Ref, yield)
+	default:
+		return yield(Change{Path: p, Kind: ChangeModified, Old: &left, New: &right}, nil)
+	}
+}
+
+func (ag *Machine) diffTrees(ctx context.Context, ls, rs schema.RO, p string, left, right Ref, yield func(Change, error) bool) bool {
+	lNext, lStop := iter.Pull2(ag.Entries(ctx, ls, left))
+	defer lStop()
+	rNext, rStop := iter.Pull2(ag.Entries(ctx, rs, right))
+	defer rStop()
+
+	lEnt, lErr, lOk := lNext()
+	rEnt, rErr, rOk := rNext()
+	for lOk || rOk {
+		for _, err := range []error{lErr, rErr} {
+			if err != nil {
+				yield(Change{}, err)
+				return false
+			}
+		}
+		var cmp int
+		switch {
+		case !lOk:
+			cmp = 1
+		case !rOk:
+			cmp = -1
+		default:
+			cmp = strings.Compare(lEnt.Name, rEnt.Name)
+		}
+		switch {
+		case cmp < 0:
+			removed := lEnt
+			if !yield(Change{Path: path.Join(p, removed.Name), Kind: ChangeRemoved, Old: &removed}, nil) {
+				return false
+			}
+			lEnt, lErr, lOk = lNext()
+		case cmp > 0:
+			added := rEnt
+			if !yield(Change{Path: path.Join(p, added.Name), Kind: ChangeAdded, New: &added}, nil) {
+				return false
+			}
+			rEnt, rErr, rOk = rNext()
+		default:
+			if !ag.diffEntry(ctx, ls, rs, path.Join(p, lEnt.Name), lEnt, rEnt, yield) {
+				return false
+			}
+			lEnt, lErr, lOk = lNext()
+			rEnt, rErr, rOk = rNext()
+		}
+	}
+	return true
+}
@@ -0,0 +1,47 @@
+package glfs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiff(t *testing.T) {
+	ctx := context.TODO()
+	ls, rs := newStore(t), newStore(t)
+	same := mustPostTree(t, ls, map[string]Ref{
+		"x": mustPostBlob(t, ls, []byte("x")),
+	})
+	require.Equal(t, same, mustPostTree(t, rs, map[string]Ref{
+		"x": mustPostBlob(t, rs, []byte("x")),
+	}))
+	left := mustPostTree(t, ls, map[string]Ref{
+		"a":        mustPostBlob(t, ls, []byte("a1")),
+		"b":        mustPostBlob(t, ls, []byte("b")),
+		"dir/c":    mustPostBlob(t, ls, []byte("c1")),
+		"removed/": mustPostTree(t, ls, nil),
+		"same":     same,
+	})
+	right := mustPostTree(t, rs, map[string]Ref{
+		"a":     mustPostBlob(t, rs, []byte("a2")),
+		"dir/c": mustPostBlob(t, rs, []byte("c2")),
+		"dir/d": mustPostBlob(t, rs, []byte("d")),
+		"e":     mustPostBlob(t, rs, []byte("e")),
+		"same":  same,
+	})
+	// the stores only contain their own side, so reading the wrong store would fail.
+	var actual []string
+	for c, err := range defaultOp.Diff(ctx, ls, left, rs, right) {
+		require.NoError(t, err)
+		actual = append(actual, c.String())
+	}
+	require.Equal(t, []string{
+		"modified a",
+		"removed b",
+		"modified dir/c",
+		"added dir/d",
+		"added e",
+		"removed removed",
+	}, actual)
+}
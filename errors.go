@@ -24,3 +24,28 @@ type ErrRefType struct {
 func (e ErrRefType) Error() string {
 	return fmt.Sprintf("wrong type HAVE: %v WANT: %v", e.Have, e.Want)
 }
+
+// ErrPrecondition is returned by Apply when the base tree does not match the state expected by a ChangeSet.
+type ErrPrecondition struct {
+	Failures []PreconditionFailure
+}
+
+func (e ErrPrecondition) Error() string {
+	return fmt.Sprintf("%d preconditions failed. first: %v", len(e.Failures), e.Failures[0])
+}
+
+func IsErrPrecondition(err error) bool {
+	return errors.As(err, new(ErrPrecondition))
+}
+
+// PreconditionFailure is a path in the base which did not have the expected Ref.
+// Expected and Actual are nil if the path does not exist.
+type PreconditionFailure struct {
+	Path     string
+	Expected *Ref
+	Actual   *Ref
+}
+
+func (pf PreconditionFailure) String() string {
+	return fmt.Sprintf("{%s HAVE: %v WANT: %v}", pf.Path, pf.Actual, pf.Expected)
+}
@@ -0,0 +1,200 @@
+package glfs
+
+import (
+	"cmp"
+	"context"
+	"iter"
+	"path"
+	"slices"
+
+	"blobcache.io/blobcache/src/blobcache"
+	"blobcache.io/blobcache/src/schema"
+)
+
+// RefLocation is a path in a root.
+type RefLocation struct {
+	Root Ref
+	Path string
+}
+
+// FindRef returns an iterator over every path in roots which references target.
+// If a root is equal to target, it is yielded with Path "".
+//
+// Each tree is only read once, even if it appears in more than one root, or at more than one path.
+// This keeps FindRef fast when searching many similar snapshots.
+func (ag *Machine) FindRef(ctx context.Context, s schema.RO, roots []Ref, target Ref) iter.Seq2[RefLocation, error] {
+	return func(yield func(RefLocation, error) bool) {
+		ri := ag.newRefIndex(s, target.Equals)
+		for _, root := range roots {
+			mt, err := ri.load(ctx, root)
+			if err != nil {
+				yield(RefLocation{}, err)
+				return
+			}
+			if !mt.walk(nil, func(names []string, _ Ref) bool {
+				return yield(RefLocation{Root: root, Path: path.Join(names...)}, nil)
+			}) {
+				return
+			}
+		}
+	}
+}
+
+// Duplicate is a blob which is referenced at more than one path.
+type Duplicate struct {
+	Ref       Ref
+	Locations []RefLocation
+}
+
+// Duplicates returns every blob which is referenced at more than one path in roots.
+// The same path in 2 different roots counts as 2 paths.
+// Empty blobs are not included.
+// Duplicates are ordered by the space they would take up if they were not deduplicated, largest first.
+func (ag *Machine) Duplicates(ctx context.Context, s schema.RO, roots ...Ref) ([]Duplicate, error) {
+	ri := ag.newRefIndex(s, func(ref Ref) bool {
+		return ref.Type == TypeBlob && ref.Size > 0
+	})
+	mts := make([]*matchTree, len(roots))
+	counts := map[blobcache.CID]int{}
+	for i, root := range roots {
+		mt, err := ri.load(ctx, root)
+		if err != nil {
+			return nil, err
+		}
+		mts[i] = mt
+		mt.walk(nil, func(_ []string, ref Ref) bool {
+			counts[ref.CID]++
+			return true
+		})
+	}
+	// paths are only built for the blobs which are duplicated.
+	dups := map[blobcache.CID]*Duplicate{}
+	for i, root := range roots {
+		mts[i].walk(nil, func(names []string, ref Ref) bool {
+			if counts[ref.CID] < 2 {
+				return true
+			}
+			dup, exists := dups[ref.CID]
+			if !exists {
+				dup = &Duplicate{Ref: ref}
+				dups[ref.CID] = dup
+			}
+			dup.Locations = append(dup.Locations, RefLocation{Root: root, Path: path.Join(names...)})
+			return true
+		})
+	}
+	var ret []Duplicate
+	for _, dup := range dups {
+		ret = append(ret, *dup)
+	}
+	slices.SortFunc(ret, func(a, b Duplicate) int {
+		aSize := a.Ref.Size * uint64(len(a.Locations))
+		bSize := b.Ref.Size * uint64(len(b.Locations))
+		if c := cmp.Compare(bSize, aSize); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Locations[0].Path, b.Locations[0].Path)
+	})
+	return ret, nil
+}
+
+// refIndex finds the paths of Refs matching a predicate.
+// The matches beneath each tree are remembered, so each tree is only read once.
+type refIndex struct {
+	ag    *Machine
+	s     schema.RO
+	match func(Ref) bool
+	// memo holds the matches beneath each tree which has been read, nil if there are none.
+	memo map[blobcache.CID]*matchTree
+}
+
+func (ag *Machine) newRefIndex(s schema.RO, match func(Ref) bool) *refIndex {
+	return &refIndex{ag: ag, s: s, match: match, memo: map[blobcache.CID]*matchTree{}}
+}
+
+// matchTree holds the entries of a tree which match, or have matches beneath them.
+// Names are relative to the tree, and subtrees are shared, so full paths are only built when they are walked.
+// A nil matchTree has no matches.
+type matchTree struct {
+	ents []matchEnt
+}
+
+type matchEnt struct {
+	name string
+	ref  Ref
+	// match is true if ref itself matches.
+	match bool
+	// below holds the matches beneath ref.
+	below *matchTree
+}
+
+// load returns the matches for ref, and everything beneath it, as a tree with a single entry for ref, named "".
+func (ri *refIndex) load(ctx context.Context, ref Ref) (*matchTree, error) {
+	ent, err := ri.loadEnt(ctx, "", ref)
+	if err != nil || ent == nil {
+		return nil, err
+	}
+	return &matchTree{ents: []matchEnt{*ent}}, nil
+}
+
+// loadEnt returns the entry for ref, or nil if there are no matches at or beneath ref.
+func (ri *refIndex) loadEnt(ctx context.Context, name string, ref Ref) (*matchEnt, error) {
+	ent := matchEnt{name: name, ref: ref, match: ri.match(ref)}
+	if ref.Type == TypeTree {
+		var err error
+		if ent.below, err = ri.loadTree(ctx, ref); err != nil {
+			return nil, err
+		}
+	}
+	if !ent.match && ent.below == nil {
+		return nil, nil
+	}
+	return &ent, nil
+}
+
+// loadTree returns the matches beneath the tree at ref.
+func (ri *refIndex) loadTree(ctx context.Context, ref Ref) (*matchTree, error) {
+	if mt, exists := ri.memo[ref.CID]; exists {
+		return mt, nil
+	}
+	var ents []matchEnt
+	for treeEnt, err := range ri.ag.Entries(ctx, ri.s, ref) {
+		if err != nil {
+			return nil, err
+		}
+		ent, err := ri.loadEnt(ctx, treeEnt.Name, treeEnt.Ref)
+		if err != nil {
+			return nil, err
+		}
+		if ent != nil {
+			ents = append(ents, *ent)
+		}
+	}
+	var mt *matchTree
+	if len(ents) > 0 {
+		mt = &matchTree{ents: ents}
+	}
+	ri.memo[ref.CID] = mt
+	return mt, nil
+}
+
+// walk calls fn with the path to every match in mt, as the names leading to it, after the names in prefix.
+// fn must not retain names.  walk stops and returns false if fn returns false.
+func (mt *matchTree) walk(prefix []string, fn func(names []string, ref Ref) bool) bool {
+	if mt == nil {
+		return true
+	}
+	for _, ent := range mt.ents {
+		names := prefix
+		if ent.name != "" {
+			names = append(prefix, ent.name)
+		}
+		if ent.match && !fn(names, ent.ref) {
+			return false
+		}
+		if !ent.below.walk(names, fn) {
+			return false
+		}
+	}
+	return true
+}
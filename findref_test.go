@@ -0,0 +1,74 @@
+package glfs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindRef(t *testing.T) {
+	ctx := context.TODO()
+	s := newStore(t)
+	secret := mustPostBlob(t, s, []byte("password123"))
+	other := mustPostBlob(t, s, []byte("hello"))
+	root1 := mustPostTree(t, s, map[string]Ref{
+		"config/secret.txt": secret,
+		"config/other.txt":  other,
+		"backup/secret.txt": secret,
+	})
+	root2 := mustPostTree(t, s, map[string]Ref{
+		"old/config/secret.txt": secret,
+		"old/config/other.txt":  other,
+		"readme.txt":            other,
+	})
+
+	var locs []RefLocation
+	for loc, err := range defaultOp.FindRef(ctx, s, []Ref{root1, root2}, secret) {
+		require.NoError(t, err)
+		locs = append(locs, loc)
+	}
+	require.Equal(t, []RefLocation{
+		{Root: root1, Path: "backup/secret.txt"},
+		{Root: root1, Path: "config/secret.txt"},
+		{Root: root2, Path: "old/config/secret.txt"},
+	}, locs)
+
+	// a tree can be searched for as well
+	config, err := GetAtPath(ctx, s, root1, "config")
+	require.NoError(t, err)
+	locs = locs[:0]
+	for loc, err := range defaultOp.FindRef(ctx, s, []Ref{root1, root2}, *config) {
+		require.NoError(t, err)
+		locs = append(locs, loc)
+	}
+	require.Equal(t, []RefLocation{
+		{Root: root1, Path: "config"},
+		{Root: root2, Path: "old/config"},
+	}, locs)
+}
+
+func TestDuplicates(t *testing.T) {
+	ctx := context.TODO()
+	s := newStore(t)
+	a := mustPostBlob(t, s, []byte("aaaaaaaaaa"))
+	b := mustPostBlob(t, s, []byte("b"))
+	root1 := mustPostTree(t, s, map[string]Ref{
+		"a1.txt":    a,
+		"x/a2.txt":  a,
+		"b.txt":     b,
+		"empty1":    mustPostBlob(t, s, nil),
+		"x/empty2":  mustPostBlob(t, s, nil),
+		"uniq.txt":  mustPostBlob(t, s, []byte("unique")),
+		"y/uniq2.t": mustPostBlob(t, s, []byte("unique2")),
+	})
+	root2 := mustPostTree(t, s, map[string]Ref{
+		"b.txt": b,
+	})
+	dups, err := defaultOp.Duplicates(ctx, s, root1, root2)
+	require.NoError(t, err)
+	require.Equal(t, []Duplicate{
+		{Ref: a, Locations: []RefLocation{{Root: root1, Path: "a1.txt"}, {Root: root1, Path: "x/a2.txt"}}},
+		{Ref: b, Locations: []RefLocation{{Root: root1, Path: "b.txt"}, {Root: root2, Path: "b.txt"}}},
+	}, dups)
+}
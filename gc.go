@@ -0,0 +1,107 @@
+package glfs
+
+import (
+	"context"
+	"iter"
+	"sync"
+
+	"blobcache.io/blobcache/src/blobcache"
+	"blobcache.io/blobcache/src/schema"
+	"blobcache.io/glfs/bigblob"
+	"golang.org/x/sync/semaphore"
+)
+
+// Verify checks that every block reachable from root exists in s,
+// and that every tree, commit, and other registered type reachable from root can be decoded.
+// If a block is missing, then blobcache.ErrNotFound is returned.
+// An error is also returned if an object of a Type which has not been registered with the Machine is reachable from root.
+func (ag *Machine) Verify(ctx context.Context, s schema.RO, root Ref) error {
+	_, err := ag.reachable(ctx, s, []Ref{root}, func(ctx context.Context, ref bigblob.Ref) error {
+		if yes, err := bigblob.ExistsUnit(ctx, s, ref.CID); err != nil {
+			return err
+		} else if !yes {
+			return blobcache.ErrNotFound{CID: ref.CID}
+		}
+		return nil
+	})
+	return err
+}
+
+// GCStore is a store which can list and delete the blobs it contains.
+type GCStore interface {
+	schema.RO
+	// List returns an iterator over every CID in the store.
+	List(ctx context.Context) iter.Seq2[blobcache.CID, error]
+	// Delete removes the blobs with cids from the store.
+	Delete(ctx context.Context, cids []blobcache.CID) error
+}
+
+// GCResult is returned by GC
+type GCResult struct {
+	// Reachable is the number of blobs which are reachable from the roots.
+	Reachable int
+	// Deleted is the number of blobs which were deleted.
+	Deleted int
+}
+
+// GC deletes every blob in s which is not reachable from one of roots.
+// Objects which are shared between roots are only visited once.
+// GC must not run concurrently with anything writing to s, otherwise newly posted data may be deleted.
+// If an object of a Type which has not been registered with the Machine is reachable from roots, then nothing is deleted and an error is returned.
+func (ag *Machine) GC(ctx context.Context, s GCStore, roots ...Ref) (*GCResult, error) {
+	keep, err := ag.reachable(ctx, s, roots, nil)
+	if err != nil {
+		return nil, err
+	}
+	var toDelete []blobcache.CID
+	for cid, err := range s.List(ctx) {
+		if err != nil {
+			return nil, err
+		}
+		if _, exists := keep[cid]; !exists {
+			toDelete = append(toDelete, cid)
+		}
+	}
+	deleted := len(toDelete)
+	const batchSize = 256
+	for len(toDelete) > 0 {
+		n := min(len(toDelete), batchSize)
+		if err := s.Delete(ctx, toDelete[:n]); err != nil {
+			return nil, err
+		}
+		toDelete = toDelete[n:]
+	}
+	return &GCResult{Reachable: len(keep), Deleted: deleted}, nil
+}
+
+// reachable returns the set of CIDs for every block reachable from roots.
+// If fn is not nil, it is called once for each block.
+func (ag *Machine) reachable(ctx context.Context, s schema.RO, roots []Ref, fn func(context.Context, bigblob.Ref) error) (map[blobcache.CID]struct{}, error) {
+	var mu sync.Mutex
+	seen := map[blobcache.CID]struct{}{}
+	sem := semaphore.NewWeighted(int64(ag.concurrency))
+	for _, root := range roots {
+		if err := ag.Traverse(ctx, s, sem, root, Traverser{
+			Enter: func(ctx context.Context, id blobcache.CID) (bool, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				_, exists := seen[id]
+				return !exists, nil
+			},
+			Exit: func(ctx context.Context, ty Type, level int, ref bigblob.Ref) error {
+				if fn != nil {
+					if err := fn(ctx, ref); err != nil {
+						return err
+					}
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				seen[ref.CID] = struct{}{}
+				return nil
+			},
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return seen, nil
+}
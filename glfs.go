@@ -6,6 +6,7 @@ import (
 	"io"
 
 	"blobcache.io/blobcache/src/bcsdk"
+	"blobcache.io/blobcache/src/schema"
 	"blobcache.io/glfs/bigblob"
 )
 
@@ -15,14 +16,15 @@ const DefaultBlockSize = 1 << 21
 type Type string
 
 const (
-	TypeBlob = Type("blob")
-	TypeTree = Type("tree")
+	TypeBlob   = Type("blob")
+	TypeTree   = Type("tree")
+	TypeCommit = Type("commit")
 )
 
 func ParseType(x []byte) (Type, error) {
 	ty := Type(x)
 	switch ty {
-	case TypeBlob, TypeTree:
+	case TypeBlob, TypeTree, TypeCommit:
 		return ty, nil
 	default:
 		return "", fmt.Errorf("%q is not a valid type", x)
@@ -32,6 +34,7 @@ func ParseType(x []byte) (Type, error) {
 // Ref is a reference to a glfs Object, which could be:
 // - Tree
 // - Blob
+// - Commit
 type Ref struct {
 	Type Type `json:"type"`
 	bigblob.Root
@@ -46,7 +49,8 @@ func (a Ref) Equals(b Ref) bool {
 }
 
 // PostTyped posts data with an arbitrary type.
-// This can be used to extend the types provided by glfs, without interfering with syncing.
+// This can be used to extend the types provided by glfs.
+// Types which should be synced or traversed must be registered with WithType.
 func (ag *Machine) PostTyped(ctx context.Context, s bcsdk.WO, ty Type, r io.Reader) (*Ref, error) {
 	tw := ag.NewTypedWriter(s, ty)
 	tw.SetWriteContext(ctx)
@@ -75,6 +79,16 @@ func (ag *Machine) NewTypedWriter(s bcsdk.WO, ty Type) *TypedWriter {
 	return &TypedWriter{ty: ty, bw: ag.bbag.NewWriter(s, ag.makeSalt(ty))}
 }
 
+// NewTypedWriterFrom returns a writer which continues the object at ref.
+// Data written to it is appended to the object, and the existing data is reused.
+func (ag *Machine) NewTypedWriterFrom(ctx context.Context, s schema.RW, ref Ref) (*TypedWriter, error) {
+	bw, err := ag.bbag.NewWriterFrom(ctx, s, ag.makeSalt(ref.Type), ref.Root)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedWriter{ty: ref.Type, bw: bw}, nil
+}
+
 func (tw *TypedWriter) SetWriteContext(ctx context.Context) {
 	tw.bw.SetWriteContext(ctx)
 }
@@ -83,6 +97,12 @@ func (tw *TypedWriter) Write(data []byte) (int, error) {
 	return tw.bw.Write(data)
 }
 
+// WriteZeros writes n zero bytes.
+// Whole blocks of zeros are stored as holes, which take up no space in the store.
+func (tw *TypedWriter) WriteZeros(n uint64) error {
+	return tw.bw.WriteZeros(n)
+}
+
 func (tw *TypedWriter) Finish(ctx context.Context) (*Ref, error) {
 	root, err := tw.bw.Finish(ctx)
 	if err != nil {
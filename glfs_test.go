@@ -3,6 +3,7 @@ package glfs
 import (
 	"context"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -40,3 +41,15 @@ func TestSync(t *testing.T) {
 		})
 	}
 }
+
+func TestAppend(t *testing.T) {
+	ctx := context.Background()
+	s := newStore(t)
+	x := MustPostBlob(s, []byte("hello "))
+	y, err := Append(ctx, s, x, strings.NewReader("world"))
+	require.NoError(t, err)
+	require.Equal(t, MustPostBlob(s, []byte("hello world")), *y)
+
+	_, err = Append(ctx, s, mustPostTree(t, s, map[string]Ref{"a": x}), strings.NewReader("world"))
+	require.ErrorAs(t, err, new(ErrRefType))
+}
@@ -0,0 +1,209 @@
+// package glfsbundle serializes glfs data into a single self-contained stream.
+//
+// A bundle starts with a magic string and a JSON header listing the roots, followed by a record for each block.
+// Blocks are written children first, so the data in a store is always complete while a bundle is being read into it.
+package glfsbundle
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"blobcache.io/blobcache/src/blobcache"
+	"blobcache.io/blobcache/src/schema"
+	"blobcache.io/glfs"
+	"blobcache.io/glfs/bigblob"
+)
+
+const magic = "GLFSBNDL\x01"
+
+// MaxHeaderSize is the maximum size of a bundle's header.
+const MaxHeaderSize = 1 << 20
+
+const (
+	recordEnd   = 0
+	recordBlock = 1
+)
+
+// Header is at the start of every bundle.
+type Header struct {
+	// Roots are the Refs which the bundle contains.
+	Roots []glfs.Ref `json:"roots"`
+	// Bases are Refs which the receiver must already have.
+	// Blocks reachable from the Bases are not included in the bundle.
+	Bases []glfs.Ref `json:"bases,omitempty"`
+}
+
+// Write writes a bundle to w containing every block in s reachable from roots.
+func Write(ctx context.Context, ag *glfs.Machine, w io.Writer, s schema.RO, roots ...glfs.Ref) error {
+	return WriteIncremental(ctx, ag, w, s, nil, roots...)
+}
+
+// WriteIncremental writes a bundle to w containing every block in s reachable from roots, and not reachable from bases.
+// The bundle can only be read into a store which already contains the bases.
+func WriteIncremental(ctx context.Context, ag *glfs.Machine, w io.Writer, s schema.RO, bases []glfs.Ref, roots ...glfs.Ref) error {
+	var mu sync.Mutex
+	seen := map[blobcache.CID]struct{}{}
+	enter := func(ctx context.Context, id blobcache.CID) (bool, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		_, exists := seen[id]
+		return !exists, nil
+	}
+	for _, base := range bases {
+		if err := ag.Traverse(ctx, s, nil, base, glfs.Traverser{
+			Enter: enter,
+			Exit: func(ctx context.Context, ty glfs.Type, level int, ref bigblob.Ref) error {
+				mu.Lock()
+				defer mu.Unlock()
+				seen[ref.CID] = struct{}{}
+				return nil
+			},
+		}); err != nil {
+			return err
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	if err := writeHeader(bw, Header{Roots: roots, Bases: bases}); err != nil {
+		return err
+	}
+	buf := make([]byte, s.MaxSize())
+	for _, root := range roots {
+		if err := ag.Traverse(ctx, s, nil, root, glfs.Traverser{
+			Enter: enter,
+			Exit: func(ctx context.Context, ty glfs.Type, level int, ref bigblob.Ref) error {
+				mu.Lock()
+				defer mu.Unlock()
+				if _, exists := seen[ref.CID]; exists {
+					return nil
+				}
+				seen[ref.CID] = struct{}{}
+				n, err := s.Get(ctx, ref.CID, buf)
+				if err != nil {
+					return err
+				}
+				return writeBlock(bw, ref.CID, buf[:n])
+			},
+		}); err != nil {
+			return err
+		}
+	}
+	if err := bw.WriteByte(recordEnd); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Read reads a bundle from r, and posts every block in it to dst.
+// The CID of each block is checked before it is posted.
+// Read returns the bundle's Header, the caller can use glfs.Machine.Verify to check that the roots are complete.
+func Read(ctx context.Context, r io.Reader, dst schema.WO) (*Header, error) {
+	br := bufio.NewReader(r)
+	h, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, dst.MaxSize())
+	for {
+		ty, err := br.ReadByte()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		switch ty {
+		case recordEnd:
+			return h, nil
+		case recordBlock:
+		default:
+			return nil, fmt.Errorf("glfsbundle: unrecognized record type %d", ty)
+		}
+		var cid blobcache.CID
+		if _, err := io.ReadFull(br, cid[:]); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		n, err := binary.ReadUvarint(br)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if n > uint64(len(buf)) {
+			return nil, fmt.Errorf("glfsbundle: block of size %d exceeds max size %d", n, len(buf))
+		}
+		data := buf[:n]
+		if _, err := io.ReadFull(br, data); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if dst.Hash(data) != cid {
+			return nil, fmt.Errorf("glfsbundle: block does not match CID %v", cid)
+		}
+		if _, err := dst.Post(ctx, data); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func writeHeader(w io.Writer, h Header) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, magic); err != nil {
+		return err
+	}
+	if _, err := w.Write(binary.AppendUvarint(nil, uint64(len(data)))); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func readHeader(br *bufio.Reader) (*Header, error) {
+	m := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, m); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if string(m) != magic {
+		return nil, errors.New("glfsbundle: not a bundle")
+	}
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if n > MaxHeaderSize {
+		return nil, fmt.Errorf("glfsbundle: header of size %d exceeds max size %d", n, MaxHeaderSize)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	var h Header
+	if err := json.Unmarshal(data, &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+func writeBlock(w *bufio.Writer, cid blobcache.CID, data []byte) error {
+	if err := w.WriteByte(recordBlock); err != nil {
+		return err
+	}
+	if _, err := w.Write(cid[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(binary.AppendUvarint(nil, uint64(len(data)))); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
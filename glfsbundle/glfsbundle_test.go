@@ -0,0 +1,72 @@
+package glfsbundle
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"blobcache.io/glfs"
+	"blobcache.io/glfs/internal/glfstest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteRead(t *testing.T) {
+	ctx := context.TODO()
+	ag := glfs.NewMachine()
+	src := glfstest.NewStore()
+	root := glfstest.GenerateTree(t, ag, src, 100)
+
+	var buf bytes.Buffer
+	require.NoError(t, Write(ctx, ag, &buf, src, root))
+
+	dst := glfstest.NewStore()
+	h, err := Read(ctx, &buf, dst)
+	require.NoError(t, err)
+	require.Equal(t, []glfs.Ref{root}, h.Roots)
+	require.NoError(t, ag.Verify(ctx, dst, root))
+	require.Equal(t, src.Len(), dst.Len())
+}
+
+func TestIncremental(t *testing.T) {
+	ctx := context.TODO()
+	ag := glfs.NewMachine()
+	src := glfstest.NewStore()
+	base := glfstest.GenerateTree(t, ag, src, 100)
+	ref, err := ag.PostBlob(ctx, src, bytes.NewReader([]byte("new")))
+	require.NoError(t, err)
+	root, err := ag.Apply(ctx, src, src, base, glfs.ChangeSet{
+		{Path: "0/new.txt", Op: glfs.EditPut, Mode: 0o644, Ref: ref},
+	})
+	require.NoError(t, err)
+
+	var full, incr bytes.Buffer
+	require.NoError(t, Write(ctx, ag, &full, src, base))
+	require.NoError(t, WriteIncremental(ctx, ag, &incr, src, []glfs.Ref{base}, *root))
+	require.Less(t, incr.Len(), full.Len()/4)
+
+	dst := glfstest.NewStore()
+	_, err = Read(ctx, &full, dst)
+	require.NoError(t, err)
+	h, err := Read(ctx, &incr, dst)
+	require.NoError(t, err)
+	require.Equal(t, []glfs.Ref{base}, h.Bases)
+	require.NoError(t, ag.Verify(ctx, dst, *root))
+}
+
+func TestCorrupt(t *testing.T) {
+	ctx := context.TODO()
+	ag := glfs.NewMachine()
+	src := glfstest.NewStore()
+	root := glfstest.GenerateTree(t, ag, src, 10)
+	var buf bytes.Buffer
+	require.NoError(t, Write(ctx, ag, &buf, src, root))
+	data := buf.Bytes()
+
+	corrupt := bytes.Clone(data)
+	corrupt[len(corrupt)-2] ^= 1
+	_, err := Read(ctx, bytes.NewReader(corrupt), glfstest.NewStore())
+	require.ErrorContains(t, err, "does not match")
+
+	_, err = Read(ctx, bytes.NewReader(data[:len(data)-1]), glfstest.NewStore())
+	require.Error(t, err)
+}
@@ -0,0 +1,238 @@
+// package glfsdir implements a content-addressed store backed by a directory in the local filesystem.
+package glfsdir
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"os"
+	"path/filepath"
+
+	"blobcache.io/blobcache/src/blobcache"
+	"blobcache.io/blobcache/src/schema"
+	"blobcache.io/glfs"
+	"blobcache.io/glfs/internal/hexcid"
+)
+
+var (
+	_ schema.RW    = &Store{}
+	_ glfs.GCStore = &Store{}
+)
+
+const tmpDir = "tmp"
+
+// listShardMin is the number of CIDs in the same shard, at which Exists lists the shard instead of checking for each blob.
+const listShardMin = 16
+
+// Store is a content-addressed store which keeps each blob in its own file.
+// Files are sharded into subdirectories by the first byte of their CID.
+// Blobs are written to a temporary file and renamed into place, so a blob is never visible partially written.
+// Store implements schema.RW, and glfs.GCStore.
+type Store struct {
+	dir     string
+	hf      blobcache.HashFunc
+	maxSize int
+}
+
+// New returns a Store using the directory at p, which is created if it does not exist.
+func New(p string, hf blobcache.HashFunc, maxSize int) (*Store, error) {
+	if err := os.MkdirAll(filepath.Join(p, tmpDir), 0o755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: p, hf: hf, maxSize: maxSize}, nil
+}
+
+func (s *Store) Post(ctx context.Context, data []byte) (blobcache.CID, error) {
+	if len(data) > s.maxSize {
+		return blobcache.CID{}, fmt.Errorf("data exceeds max size %d", s.maxSize)
+	}
+	cid := s.hf(data)
+	p := s.path(cid)
+	if _, err := os.Stat(p); err == nil {
+		return cid, nil
+	}
+	if shard := filepath.Dir(p); !dirExists(shard) {
+		if err := os.MkdirAll(shard, 0o755); err != nil {
+			return blobcache.CID{}, err
+		}
+		if err := syncDir(s.dir); err != nil {
+			return blobcache.CID{}, err
+		}
+	}
+	f, err := os.CreateTemp(filepath.Join(s.dir, tmpDir), "*")
+	if err != nil {
+		return blobcache.CID{}, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return blobcache.CID{}, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return blobcache.CID{}, err
+	}
+	if err := f.Close(); err != nil {
+		return blobcache.CID{}, err
+	}
+	if err := os.Rename(f.Name(), p); err != nil {
+		return blobcache.CID{}, err
+	}
+	// the rename is only durable once the directory containing the blob is synced.
+	if err := syncDir(filepath.Dir(p)); err != nil {
+		return blobcache.CID{}, err
+	}
+	return cid, nil
+}
+
+// Get reads the blob with cid into buf.
+// The data is hashed, and an error is returned if it does not match cid.
+func (s *Store) Get(ctx context.Context, cid blobcache.CID, buf []byte) (int, error) {
+	f, err := os.Open(s.path(cid))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return 0, blobcache.ErrNotFound{CID: cid}
+		}
+		return 0, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, int64(s.maxSize)+1))
+	if err != nil {
+		return 0, err
+	}
+	if s.hf(data) != cid {
+		return 0, fmt.Errorf("glfsdir: data for %v is corrupt", cid)
+	}
+	if len(buf) < len(data) {
+		return 0, io.ErrShortBuffer
+	}
+	return copy(buf, data), nil
+}
+
+// Exists checks for the blobs with cids.
+// When many of the CIDs are in the same shard, the shard is listed once, instead of checking for each blob.
+func (s *Store) Exists(ctx context.Context, cids []blobcache.CID, dst []bool) error {
+	byShard := map[string][]int{}
+	for i, cid := range cids {
+		shard := filepath.Dir(s.path(cid))
+		byShard[shard] = append(byShard[shard], i)
+	}
+	for shard, idxs := range byShard {
+		if len(idxs) < listShardMin {
+			for _, i := range idxs {
+				_, err := os.Stat(s.path(cids[i]))
+				switch {
+				case err == nil:
+					dst[i] = true
+				case errors.Is(err, fs.ErrNotExist):
+					dst[i] = false
+				default:
+					return err
+				}
+			}
+			continue
+		}
+		names, err := readNames(shard)
+		if err != nil {
+			return err
+		}
+		for _, i := range idxs {
+			_, dst[i] = names[filepath.Base(s.path(cids[i]))]
+		}
+	}
+	return nil
+}
+
+// Delete removes the blobs with cids.  It is not an error if a blob does not exist.
+func (s *Store) Delete(ctx context.Context, cids []blobcache.CID) error {
+	for _, cid := range cids {
+		if err := os.Remove(s.path(cid)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// List returns an iterator over the CID of every blob in the store, in sorted order.
+func (s *Store) List(ctx context.Context) iter.Seq2[blobcache.CID, error] {
+	return func(yield func(blobcache.CID, error) bool) {
+		shards, err := os.ReadDir(s.dir)
+		if err != nil {
+			yield(blobcache.CID{}, err)
+			return
+		}
+		for _, shard := range shards {
+			if !shard.IsDir() || shard.Name() == tmpDir {
+				continue
+			}
+			ents, err := os.ReadDir(filepath.Join(s.dir, shard.Name()))
+			if err != nil {
+				yield(blobcache.CID{}, err)
+				return
+			}
+			for _, ent := range ents {
+				cid, err := hexcid.Parse(shard.Name() + ent.Name())
+				if err != nil {
+					// ignore files which are not blobs.
+					continue
+				}
+				if !yield(cid, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Store) Hash(data []byte) blobcache.CID {
+	return s.hf(data)
+}
+
+func (s *Store) MaxSize() int {
+	return s.maxSize
+}
+
+func (s *Store) path(cid blobcache.CID) string {
+	x := hex.EncodeToString(cid[:])
+	return filepath.Join(s.dir, x[:2], x[2:])
+}
+
+// readNames returns the names in the directory p, or nothing if p does not exist.
+func readNames(p string) (map[string]struct{}, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		m[name] = struct{}{}
+	}
+	return m, nil
+}
+
+func dirExists(p string) bool {
+	finfo, err := os.Stat(p)
+	return err == nil && finfo.IsDir()
+}
+
+// syncDir syncs the directory at p, so that entries which were added to it are durable.
+func syncDir(p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
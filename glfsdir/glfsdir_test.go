@@ -0,0 +1,87 @@
+package glfsdir
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"blobcache.io/blobcache/src/blobcache"
+	"blobcache.io/glfs"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostGet(t *testing.T) {
+	ctx := context.TODO()
+	s := newTestStore(t)
+	cid, err := s.Post(ctx, []byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, s.MaxSize())
+	n, err := s.Get(ctx, cid, buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf[:n]))
+
+	missing := s.Hash([]byte("missing"))
+	_, err = s.Get(ctx, missing, buf)
+	require.ErrorIs(t, err, blobcache.ErrNotFound{CID: missing})
+	exists := make([]bool, 2)
+	require.NoError(t, s.Exists(ctx, []blobcache.CID{cid, missing}, exists))
+	require.Equal(t, []bool{true, false}, exists)
+
+	// enough CIDs in one shard, that the shard is listed.
+	var cids []blobcache.CID
+	for i := 0; i < listShardMin; i++ {
+		cid2 := cid
+		cid2[len(cid2)-1] += byte(i)
+		cids = append(cids, cid2)
+	}
+	exists = make([]bool, len(cids))
+	require.NoError(t, s.Exists(ctx, cids, exists))
+	require.Equal(t, append([]bool{true}, make([]bool, listShardMin-1)...), exists)
+
+	// corrupt data is detected
+	require.NoError(t, os.WriteFile(s.path(cid), []byte("jello"), 0o644))
+	_, err = s.Get(ctx, cid, buf)
+	require.ErrorContains(t, err, "corrupt")
+}
+
+func TestGC(t *testing.T) {
+	ctx := context.TODO()
+	ag := glfs.NewMachine()
+	s := newTestStore(t)
+	post := func(m map[string]string) glfs.Ref {
+		m2 := map[string]glfs.Ref{}
+		for k, v := range m {
+			ref, err := ag.PostBlob(ctx, s, bytes.NewReader([]byte(v)))
+			require.NoError(t, err)
+			m2[k] = *ref
+		}
+		ref, err := ag.PostTreeMap(ctx, s, m2)
+		require.NoError(t, err)
+		return *ref
+	}
+	keep := post(map[string]string{"a.txt": "a", "b/c.txt": "c"})
+	post(map[string]string{"garbage.txt": "garbage"})
+
+	var before int
+	for _, err := range s.List(ctx) {
+		require.NoError(t, err)
+		before++
+	}
+	res, err := ag.GC(ctx, s, keep)
+	require.NoError(t, err)
+	require.Equal(t, 2, res.Deleted)
+	require.Equal(t, before-2, res.Reachable)
+	require.NoError(t, ag.Verify(ctx, s, keep))
+
+	// a new Store using the same directory sees the same data.
+	s2, err := New(s.dir, s.hf, s.maxSize)
+	require.NoError(t, err)
+	require.NoError(t, ag.Verify(ctx, s2, keep))
+}
+
+func newTestStore(t testing.TB) *Store {
+	s, err := New(t.TempDir(), blobcache.HashAlgo_BLAKE3_256.HashFunc(), glfs.DefaultBlockSize)
+	require.NoError(t, err)
+	return s
+}
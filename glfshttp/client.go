@@ -0,0 +1,151 @@
+package glfshttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"blobcache.io/blobcache/src/blobcache"
+	"blobcache.io/blobcache/src/schema"
+	"blobcache.io/glfs/internal/hexcid"
+)
+
+var _ schema.RW = &Client{}
+
+// Client accesses a store exposed by a Server.
+// It implements schema.RO and schema.WO.
+type Client struct {
+	hc       *http.Client
+	endpoint string
+	hf       blobcache.HashFunc
+	maxSize  int
+}
+
+// NewClient returns a Client for the Server at endpoint.
+// hf and maxSize must match the store exposed by the Server.
+// If hc is nil, http.DefaultClient is used.
+func NewClient(hc *http.Client, endpoint string, hf blobcache.HashFunc, maxSize int) *Client {
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	return &Client{
+		hc:       hc,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		hf:       hf,
+		maxSize:  maxSize,
+	}
+}
+
+func (c *Client) Get(ctx context.Context, cid blobcache.CID, buf []byte) (int, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/blob/"+hex.EncodeToString(cid[:]), nil)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return 0, blobcache.ErrNotFound{CID: cid}
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(c.maxSize)+1))
+	if err != nil {
+		return 0, err
+	}
+	if len(data) > c.maxSize {
+		return 0, fmt.Errorf("blob exceeds max size %d", c.maxSize)
+	}
+	if c.hf(data) != cid {
+		return 0, fmt.Errorf("server returned bad data for %v", cid)
+	}
+	if len(buf) < len(data) {
+		return 0, io.ErrShortBuffer
+	}
+	return copy(buf, data), nil
+}
+
+func (c *Client) Post(ctx context.Context, data []byte) (blobcache.CID, error) {
+	if len(data) > c.maxSize {
+		return blobcache.CID{}, fmt.Errorf("data exceeds max size %d", c.maxSize)
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/blob", data)
+	if err != nil {
+		return blobcache.CID{}, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 2*blobcache.CIDSize))
+	if err != nil {
+		return blobcache.CID{}, err
+	}
+	cid, err := hexcid.Parse(string(body))
+	if err != nil {
+		return blobcache.CID{}, err
+	}
+	if cid != c.hf(data) {
+		return blobcache.CID{}, fmt.Errorf("server returned wrong CID for posted data")
+	}
+	return cid, nil
+}
+
+// Exists checks for the existence of cids, in batches of at most MaxExistsBatch.
+func (c *Client) Exists(ctx context.Context, cids []blobcache.CID, dst []bool) error {
+	for len(cids) > 0 {
+		n := min(len(cids), MaxExistsBatch)
+		if err := c.exists(ctx, cids[:n], dst[:n]); err != nil {
+			return err
+		}
+		cids, dst = cids[n:], dst[n:]
+	}
+	return nil
+}
+
+func (c *Client) exists(ctx context.Context, cids []blobcache.CID, dst []bool) error {
+	body := make([]byte, 0, len(cids)*blobcache.CIDSize)
+	for _, cid := range cids {
+		body = append(body, cid[:]...)
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/exists", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, int64(len(cids))+1))
+	if err != nil {
+		return err
+	}
+	if len(data) != len(cids) {
+		return fmt.Errorf("exists response has wrong length. HAVE: %d WANT: %d", len(data), len(cids))
+	}
+	for i := range data {
+		dst[i] = data[i] == 1
+	}
+	return nil
+}
+
+func (c *Client) Hash(data []byte) blobcache.CID {
+	return c.hf(data)
+}
+
+func (c *Client) MaxSize() int {
+	return c.maxSize
+}
+
+// do sends a request, and returns an error if the response does not have status 200.
+// The response is returned along with the error, with its body closed.
+func (c *Client) do(ctx context.Context, method, p string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.endpoint+p, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp, fmt.Errorf("glfshttp: %s %s: %s: %s", method, p, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
@@ -0,0 +1,255 @@
+package glfshttp
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+
+	"blobcache.io/blobcache/src/blobcache"
+	"blobcache.io/blobcache/src/schema"
+	"blobcache.io/glfs"
+	"blobcache.io/glfs/internal/hexcid"
+)
+
+// MaxPinnedRoots is the number of recent roots which a FileServer will serve by CID.
+const MaxPinnedRoots = 1024
+
+// RootResolver returns the root which should currently be served.
+// The root can be a tree, or a commit.
+type RootResolver = func(ctx context.Context) (*glfs.Ref, error)
+
+// FileServer is an http.Handler serving the files in a glfs tree.
+//
+// Files are served with support for Range requests, and a strong ETag derived from the file's CID.
+// Trees are served as an HTML listing, or as JSON if the request has ?format=json or accepts application/json.
+//
+// A URL of the form /@{cid}/{path} pins the root with the hex encoded CID.
+// Responses to pinned URLs never change, and are marked as immutable.
+// Only roots which the FileServer has served recently can be pinned.
+// Responses to unpinned URLs have a Content-Location header with the pinned URL.
+type FileServer struct {
+	ag      *glfs.Machine
+	s       schema.RO
+	resolve RootResolver
+	pinned  *lru.Cache[blobcache.CID, glfs.Ref]
+}
+
+// NewFileServer returns a FileServer for the root returned by resolve.
+// resolve is called for every request, which does not pin a root.
+func NewFileServer(ag *glfs.Machine, s schema.RO, resolve RootResolver) *FileServer {
+	pinned, err := lru.New[blobcache.CID, glfs.Ref](MaxPinnedRoots)
+	if err != nil {
+		panic(err)
+	}
+	return &FileServer{
+		ag:      ag,
+		s:       s,
+		resolve: resolve,
+		pinned:  pinned,
+	}
+}
+
+// NewStaticFileServer returns a FileServer which always serves root.
+func NewStaticFileServer(ag *glfs.Machine, s schema.RO, root glfs.Ref) *FileServer {
+	return NewFileServer(ag, s, func(ctx context.Context) (*glfs.Ref, error) {
+		return &root, nil
+	})
+}
+
+func (fsrv *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx := r.Context()
+	p := strings.TrimPrefix(r.URL.Path, "/")
+	var root glfs.Ref
+	var pinned bool
+	if rest, ok := strings.CutPrefix(p, "@"); ok {
+		cidHex, subpath, _ := strings.Cut(rest, "/")
+		cid, err := hexcid.Parse(cidHex)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if root, ok = fsrv.pinned.Get(cid); !ok {
+			http.Error(w, "root not found", http.StatusNotFound)
+			return
+		}
+		pinned, p = true, subpath
+	} else {
+		ref, err := fsrv.resolve(ctx)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		root = *ref
+		fsrv.pinned.Add(root.CID, root)
+	}
+
+	var modTime time.Time
+	tree := root
+	if root.Type == glfs.TypeCommit {
+		c, err := fsrv.ag.GetCommit(ctx, fsrv.s, root)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		tree, modTime = c.Root, c.Time
+	}
+	ent, err := fsrv.ag.Lookup(ctx, fsrv.s, glfs.TreeEntry{Ref: tree}, p)
+	if err != nil {
+		if glfs.IsErrNoEnt(err) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		writeError(w, err)
+		return
+	}
+
+	h := w.Header()
+	h.Set("ETag", etag(ent.Ref))
+	if pinned {
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		h.Set("Cache-Control", "no-cache")
+		h.Set("Content-Location", (&url.URL{Path: "/@" + hex.EncodeToString(root.CID[:]) + "/" + p}).EscapedPath())
+	}
+	switch {
+	case ent.FileMode&os.ModeSymlink != 0:
+		fsrv.serveSymlink(w, r, p, *ent)
+	case ent.Ref.Type == glfs.TypeTree:
+		fsrv.serveTree(w, r, *ent, modTime)
+	case ent.Ref.Type == glfs.TypeBlob:
+		fsrv.serveBlob(w, r, *ent, modTime)
+	default:
+		http.Error(w, fmt.Sprintf("cannot serve object of type %s", ent.Ref.Type), http.StatusNotFound)
+	}
+}
+
+func (fsrv *FileServer) serveBlob(w http.ResponseWriter, r *http.Request, ent glfs.TreeEntry, modTime time.Time) {
+	br, err := fsrv.ag.GetBlob(r.Context(), fsrv.s, ent.Ref)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	rs := io.NewSectionReader(br, 0, int64(ent.Ref.Size))
+	http.ServeContent(w, r, path.Base(r.URL.Path), modTime, rs)
+}
+
+// serveSymlink redirects to the target of the symlink at p.
+// Targets outside of the tree are not found, so that a redirect cannot leave a pinned root.
+func (fsrv *FileServer) serveSymlink(w http.ResponseWriter, r *http.Request, p string, ent glfs.TreeEntry) {
+	data, err := fsrv.ag.GetBlobBytes(r.Context(), fsrv.s, ent.Ref, 4096)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	target := string(data)
+	// the target is resolved the same way as http.Redirect resolves it against the request's path.
+	dir, _ := path.Split(p)
+	if path.IsAbs(target) || !fs.ValidPath(path.Join(dir, target)) {
+		http.Error(w, "symlink leaves the tree", http.StatusNotFound)
+		return
+	}
+	w.Header().Del("ETag")
+	http.Redirect(w, r, (&url.URL{Path: target}).EscapedPath(), http.StatusFound)
+}
+
+// DirEntry is an element of a JSON directory listing.
+type DirEntry struct {
+	Name string      `json:"name"`
+	Type glfs.Type   `json:"type"`
+	Mode os.FileMode `json:"mode"`
+	Size uint64      `json:"size"`
+}
+
+func (fsrv *FileServer) serveTree(w http.ResponseWriter, r *http.Request, ent glfs.TreeEntry, modTime time.Time) {
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		http.Redirect(w, r, path.Base(r.URL.Path)+"/", http.StatusMovedPermanently)
+		return
+	}
+	asJSON := r.URL.Query().Get("format") == "json" || strings.Contains(r.Header.Get("Accept"), "application/json")
+	w.Header().Add("Vary", "Accept")
+	if asJSON {
+		// the listings have different contents, so they need different ETags.
+		w.Header().Set("ETag", `"`+hex.EncodeToString(ent.Ref.CID[:])+`-json"`)
+	}
+	if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, w.Header().Get("ETag")) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	var ents []DirEntry
+	for ent, err := range fsrv.ag.Entries(r.Context(), fsrv.s, ent.Ref) {
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		ents = append(ents, DirEntry{
+			Name: ent.Name,
+			Type: ent.Ref.Type,
+			Mode: ent.FileMode,
+			Size: ent.Ref.Size,
+		})
+	}
+	if !modTime.IsZero() {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+	if asJSON {
+		w.Header().Set("Content-Type", "application/json")
+		if ents == nil {
+			ents = []DirEntry{}
+		}
+		if r.Method != http.MethodHead {
+			json.NewEncoder(w).Encode(ents)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	fmt.Fprintf(w, "<!doctype html>\n<meta charset=\"utf-8\">\n<title>%s</title>\n<pre>\n", html.EscapeString(r.URL.Path))
+	for _, ent := range ents {
+		name := ent.Name
+		if ent.Type == glfs.TypeTree {
+			name += "/"
+		}
+		href := (&url.URL{Path: name}).EscapedPath()
+		if strings.Contains(name, ":") {
+			// don't let the name be interpretted as a scheme
+			href = "./" + href
+		}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", html.EscapeString(href), html.EscapeString(name))
+	}
+	fmt.Fprintf(w, "</pre>\n")
+}
+
+// etag returns a strong ETag for the object at ref.
+func etag(ref glfs.Ref) string {
+	return `"` + hex.EncodeToString(ref.CID[:]) + `"`
+}
+
+// etagMatches returns true if the If-None-Match header value matches tag.
+func etagMatches(header, tag string) bool {
+	for _, x := range strings.Split(header, ",") {
+		x = strings.TrimSpace(x)
+		if x == "*" || strings.TrimPrefix(x, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
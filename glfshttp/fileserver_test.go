@@ -0,0 +1,144 @@
+package glfshttp
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"blobcache.io/glfs"
+	"blobcache.io/glfs/internal/glfstest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileServer(t *testing.T) {
+	ctx := context.TODO()
+	ag := glfs.NewMachine()
+	s := glfstest.NewStore()
+	post := func(data string) glfs.Ref {
+		ref, err := ag.PostBlob(ctx, s, strings.NewReader(data))
+		require.NoError(t, err)
+		return *ref
+	}
+	root1, err := ag.PostTreeMap(ctx, s, map[string]glfs.Ref{
+		"a.txt":     post("0123456789"),
+		"dir/b.txt": post("hello world"),
+	})
+	require.NoError(t, err)
+	root2, err := ag.PostTreeMap(ctx, s, map[string]glfs.Ref{
+		"a.txt": post("changed"),
+	})
+	require.NoError(t, err)
+
+	current := root1
+	fsrv := NewFileServer(ag, s, func(ctx context.Context) (*glfs.Ref, error) {
+		return current, nil
+	})
+	hsrv := httptest.NewServer(fsrv)
+	defer hsrv.Close()
+	get := func(p string, hdr map[string]string) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodGet, hsrv.URL+p, nil)
+		require.NoError(t, err)
+		for k, v := range hdr {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp, string(data)
+	}
+
+	resp, body := get("/a.txt", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "0123456789", body)
+	tag := resp.Header.Get("ETag")
+	require.NotEmpty(t, tag)
+	require.Equal(t, "no-cache", resp.Header.Get("Cache-Control"))
+	pinnedURL := resp.Header.Get("Content-Location")
+	require.True(t, strings.HasPrefix(pinnedURL, "/@"))
+
+	resp, body = get("/a.txt", map[string]string{"Range": "bytes=2-4"})
+	require.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	require.Equal(t, "234", body)
+	resp, _ = get("/a.txt", map[string]string{"If-None-Match": tag})
+	require.Equal(t, http.StatusNotModified, resp.StatusCode)
+	resp, _ = get("/missing.txt", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// directories
+	resp, body = get("/dir", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Contains(t, body, `<a href="b.txt">b.txt</a>`)
+	resp, body = get("/?format=json", nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var ents []DirEntry
+	require.NoError(t, json.Unmarshal([]byte(body), &ents))
+	require.Len(t, ents, 2)
+	require.Equal(t, "a.txt", ents[0].Name)
+	require.Equal(t, glfs.TypeTree, ents[1].Type)
+	resp, _ = get("/", map[string]string{"If-None-Match": resp.Header.Get("ETag"), "Accept": "application/json"})
+	require.Equal(t, http.StatusNotModified, resp.StatusCode)
+
+	// the pinned URL continues to serve the old root after it changes.
+	current = root2
+	_, body = get("/a.txt", nil)
+	require.Equal(t, "changed", body)
+	resp, body = get(pinnedURL, nil)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "0123456789", body)
+	require.Contains(t, resp.Header.Get("Cache-Control"), "immutable")
+	resp, _ = get("/@"+strings.Repeat("00", 32)+"/a.txt", nil)
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestFileServerSymlinks(t *testing.T) {
+	ctx := context.TODO()
+	ag := glfs.NewMachine()
+	s := glfstest.NewStore()
+	post := func(data string) glfs.Ref {
+		ref, err := ag.PostBlob(ctx, s, strings.NewReader(data))
+		require.NoError(t, err)
+		return *ref
+	}
+	link := func(name, target string) glfs.TreeEntry {
+		return glfs.TreeEntry{Name: name, FileMode: os.ModeSymlink | 0o777, Ref: post(target)}
+	}
+	sub, err := ag.PostTreeSlice(ctx, s, []glfs.TreeEntry{
+		link("up", "../a.txt"),
+		link("escape", "../../a.txt"),
+	})
+	require.NoError(t, err)
+	root, err := ag.PostTreeSlice(ctx, s, []glfs.TreeEntry{
+		{Name: "a.txt", FileMode: 0o644, Ref: post("hello")},
+		link("abs", "/a.txt"),
+		{Name: "sub", FileMode: os.ModeDir | 0o755, Ref: *sub},
+	})
+	require.NoError(t, err)
+	hsrv := httptest.NewServer(NewStaticFileServer(ag, s, *root))
+	defer hsrv.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	get := func(p string) *http.Response {
+		resp, err := client.Get(hsrv.URL + p)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return resp
+	}
+
+	pinned := strings.TrimSuffix(get("/a.txt").Header.Get("Content-Location"), "a.txt")
+	for _, prefix := range []string{"/", pinned} {
+		resp := get(prefix + "sub/up")
+		require.Equal(t, http.StatusFound, resp.StatusCode)
+		require.Equal(t, prefix+"a.txt", resp.Header.Get("Location"))
+		// targets which leave the tree would also leave the pinned root.
+		require.Equal(t, http.StatusNotFound, get(prefix+"sub/escape").StatusCode)
+		require.Equal(t, http.StatusNotFound, get(prefix+"abs").StatusCode)
+	}
+}
@@ -0,0 +1,161 @@
+package glfshttp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"blobcache.io/blobcache/src/blobcache"
+	"blobcache.io/blobcache/src/schema"
+	"blobcache.io/glfs"
+	"blobcache.io/glfs/internal/glfstest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPull(t *testing.T) {
+	ctx := context.TODO()
+	ag := glfs.NewMachine()
+	remote := glfstest.NewStore()
+	root := glfstest.GenerateTree(t, ag, remote, 100)
+	secret, err := ag.PostBlob(ctx, remote, bytes.NewReader([]byte("not reachable")))
+	require.NoError(t, err)
+
+	srv, err := NewServer(ctx, ag, remote, root)
+	require.NoError(t, err)
+	c, counts := newTestClient(t, srv)
+
+	// only data reachable from the roots is visible
+	_, err = c.Get(ctx, secret.CID, make([]byte, c.MaxSize()))
+	require.ErrorIs(t, err, blobcache.ErrNotFound{CID: secret.CID})
+
+	local := glfstest.NewStore()
+	require.NoError(t, Sync(ctx, ag, local, c, root))
+	require.NoError(t, ag.Verify(ctx, local, root))
+	require.Equal(t, remote.Len()-1, local.Len())
+	require.Equal(t, 0, counts.get("POST /blob"))
+
+	// syncing again, only needs to check the root.
+	counts.reset()
+	require.NoError(t, Sync(ctx, ag, local, c, root))
+	require.Equal(t, 0, counts.get("GET /blob"))
+}
+
+func TestPush(t *testing.T) {
+	ctx := context.TODO()
+	ag := glfs.NewMachine()
+	local := glfstest.NewStore()
+	root1 := glfstest.GenerateTree(t, ag, local, 100)
+
+	remote := glfstest.NewStore()
+	srv, err := NewServer(ctx, ag, remote)
+	require.NoError(t, err)
+	c, counts := newTestClient(t, srv)
+
+	require.NoError(t, Sync(ctx, ag, c, local, root1))
+	require.NoError(t, ag.Verify(ctx, remote, root1))
+	require.Equal(t, local.Len(), counts.get("POST /blob"))
+	// there are 3 levels: the root, 10 subdirectories, and the blobs.
+	require.LessOrEqual(t, counts.get("POST /exists"), 6)
+
+	// root2 shares most of its data with root1, only the new data is sent.
+	before := local.Len()
+	ref, err := ag.PostBlob(ctx, local, bytes.NewReader([]byte("new")))
+	require.NoError(t, err)
+	root2, err := ag.Apply(ctx, local, local, root1, glfs.ChangeSet{
+		{Path: "0/new.txt", Op: glfs.EditPut, Mode: 0o644, Ref: ref},
+	})
+	require.NoError(t, err)
+	counts.reset()
+	require.NoError(t, Sync(ctx, ag, c, local, *root2))
+	require.NoError(t, ag.Verify(ctx, remote, *root2))
+	require.Equal(t, local.Len()-before, counts.get("POST /blob"))
+}
+
+type requestCounts struct {
+	mu sync.Mutex
+	m  map[string]int
+}
+
+func (rc *requestCounts) get(k string) int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.m[k]
+}
+
+func (rc *requestCounts) reset() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.m = map[string]int{}
+}
+
+func newTestClient(t testing.TB, srv *Server) (*Client, *requestCounts) {
+	counts := &requestCounts{m: map[string]int{}}
+	hsrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		k := r.Method + " /exists"
+		if r.URL.Path != "/exists" {
+			k = r.Method + " /blob"
+		}
+		counts.mu.Lock()
+		counts.m[k]++
+		counts.mu.Unlock()
+		srv.ServeHTTP(w, r)
+	}))
+	t.Cleanup(hsrv.Close)
+	return NewClient(hsrv.Client(), hsrv.URL, blobcache.HashAlgo_BLAKE3_256.HashFunc(), glfs.DefaultBlockSize), counts
+}
+
+func TestSyncInterrupted(t *testing.T) {
+	ctx := context.TODO()
+	ag := glfs.NewMachine()
+	src := glfstest.NewStore()
+	x, err := ag.PostBlob(ctx, src, bytes.NewReader([]byte("shared")))
+	require.NoError(t, err)
+	y, err := ag.PostBlob(ctx, src, bytes.NewReader([]byte("y")))
+	require.NoError(t, err)
+	// x is referenced at two depths, so it is found at the shallower one first.
+	root, err := ag.PostTreeMap(ctx, src, map[string]glfs.Ref{
+		"a/c/x": *x,
+		"a/c/y": *y,
+		"b/x":   *x,
+	})
+	require.NoError(t, err)
+
+	for n := 0; ; n++ {
+		dst := glfstest.NewStore()
+		err := Sync(ctx, ag, &failingStore{MemStore: dst, n: n}, src, *root)
+		// every object in dst is complete, no matter when Sync stopped.
+		require.NoError(t, glfs.WalkRefs(ctx, src, *root, func(ref glfs.Ref) error {
+			have, err := exists(ctx, dst, []blobcache.CID{ref.CID})
+			if err != nil || !have[0] {
+				return err
+			}
+			return ag.Verify(ctx, dst, ref)
+		}), "n=%d", n)
+		if err == nil {
+			break
+		}
+		require.ErrorIs(t, err, errInterrupted)
+		require.NoError(t, Sync(ctx, ag, dst, src, *root))
+		require.NoError(t, ag.Verify(ctx, dst, *root))
+	}
+}
+
+var errInterrupted = errors.New("interrupted")
+
+// failingStore fails every Post after the first n.
+type failingStore struct {
+	*schema.MemStore
+	n int
+}
+
+func (s *failingStore) Post(ctx context.Context, data []byte) (blobcache.CID, error) {
+	if s.n == 0 {
+		return blobcache.CID{}, errInterrupted
+	}
+	s.n--
+	return s.MemStore.Post(ctx, data)
+}
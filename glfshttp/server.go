@@ -0,0 +1,175 @@
+// package glfshttp transfers glfs data between machines over HTTP, and serves glfs trees to HTTP clients.
+//
+// The protocol has 3 endpoints:
+//
+//	GET  /blob/{cid}  returns the blob with the hex encoded cid.
+//	POST /blob        posts the request body, and returns the hex encoded CID.
+//	POST /exists      the request body is a list of raw CIDs, the response has a byte for each, 1 if it exists and 0 if not.
+package glfshttp
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+
+	"blobcache.io/blobcache/src/blobcache"
+	"blobcache.io/blobcache/src/schema"
+	"blobcache.io/glfs"
+	"blobcache.io/glfs/bigblob"
+	"blobcache.io/glfs/internal/hexcid"
+)
+
+// MaxExistsBatch is the maximum number of CIDs in a single exists request.
+const MaxExistsBatch = 4096
+
+// Server exposes a store over HTTP.
+// Only blobs reachable from the Server's roots, or posted through the Server, can be read.
+// If the store implements schema.WO, then blobs can also be posted.
+type Server struct {
+	ag  *glfs.Machine
+	s   schema.RO
+	mux *http.ServeMux
+
+	mu       sync.RWMutex
+	readable map[blobcache.CID]struct{}
+}
+
+// NewServer returns a Server exposing the data in s reachable from roots.
+func NewServer(ctx context.Context, ag *glfs.Machine, s schema.RO, roots ...glfs.Ref) (*Server, error) {
+	srv := &Server{
+		ag:       ag,
+		s:        s,
+		readable: map[blobcache.CID]struct{}{},
+		mux:      http.NewServeMux(),
+	}
+	srv.mux.HandleFunc("GET /blob/{cid}", srv.handleGet)
+	srv.mux.HandleFunc("POST /blob", srv.handlePost)
+	srv.mux.HandleFunc("POST /exists", srv.handleExists)
+	for _, root := range roots {
+		if err := srv.AddRoot(ctx, root); err != nil {
+			return nil, err
+		}
+	}
+	return srv, nil
+}
+
+// AddRoot makes all the data reachable from root readable.
+func (srv *Server) AddRoot(ctx context.Context, root glfs.Ref) error {
+	var mu sync.Mutex
+	reachable := map[blobcache.CID]struct{}{}
+	if err := srv.ag.Traverse(ctx, srv.s, nil, root, glfs.Traverser{
+		Enter: func(ctx context.Context, id blobcache.CID) (bool, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			_, exists := reachable[id]
+			return !exists, nil
+		},
+		Exit: func(ctx context.Context, ty glfs.Type, level int, ref bigblob.Ref) error {
+			mu.Lock()
+			defer mu.Unlock()
+			reachable[ref.CID] = struct{}{}
+			return nil
+		},
+	}); err != nil {
+		return err
+	}
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	for cid := range reachable {
+		srv.readable[cid] = struct{}{}
+	}
+	return nil
+}
+
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv.mux.ServeHTTP(w, r)
+}
+
+func (srv *Server) handleGet(w http.ResponseWriter, r *http.Request) {
+	cid, err := hexcid.Parse(r.PathValue("cid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !srv.isReadable(cid) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	buf := make([]byte, srv.s.MaxSize())
+	n, err := srv.s.Get(r.Context(), cid, buf)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(buf[:n])
+}
+
+func (srv *Server) handlePost(w http.ResponseWriter, r *http.Request) {
+	s, ok := srv.s.(schema.WO)
+	if !ok {
+		http.Error(w, "store is read-only", http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(s.MaxSize())))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	cid, err := s.Post(r.Context(), data)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	srv.mu.Lock()
+	srv.readable[cid] = struct{}{}
+	srv.mu.Unlock()
+	io.WriteString(w, hex.EncodeToString(cid[:]))
+}
+
+func (srv *Server) handleExists(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxExistsBatch*blobcache.CIDSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if len(data)%blobcache.CIDSize != 0 {
+		http.Error(w, "body must be a list of CIDs", http.StatusBadRequest)
+		return
+	}
+	cids := make([]blobcache.CID, len(data)/blobcache.CIDSize)
+	for i := range cids {
+		cids[i] = blobcache.CID(data[i*blobcache.CIDSize:])
+	}
+	exists := make([]bool, len(cids))
+	if err := srv.s.Exists(r.Context(), cids, exists); err != nil {
+		writeError(w, err)
+		return
+	}
+	resp := make([]byte, len(cids))
+	for i := range cids {
+		if exists[i] && srv.isReadable(cids[i]) {
+			resp[i] = 1
+		}
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(resp)
+}
+
+func (srv *Server) isReadable(cid blobcache.CID) bool {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+	_, exists := srv.readable[cid]
+	return exists
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	if errors.As(err, new(blobcache.ErrNotFound)) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
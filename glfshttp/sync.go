@@ -0,0 +1,135 @@
+package glfshttp
+
+import (
+	"context"
+	"fmt"
+
+	"blobcache.io/blobcache/src/blobcache"
+	"blobcache.io/blobcache/src/schema"
+	"blobcache.io/glfs"
+	"blobcache.io/glfs/bigblob"
+)
+
+// Sync ensures that all the data reachable from root exists in dst, copying it from src.
+// Either store can be a Client, so Sync can push to, or pull from, a Server.
+//
+// glfs.Sync checks for each block in dst as it is visited, which is a round trip per block over HTTP.
+// Instead, Sync works breadth first, and asks dst which objects it has for a whole level of the filesystem at once.
+// Then it asks which blocks of the missing objects dst has, all at once.
+// Only the blocks which dst is missing are read from src and posted to dst.
+//
+// Like glfs.Sync, it assumes that if an object exists in dst, then everything it references does too.
+// Blocks are posted children first, so that assumption holds even if Sync is interrupted.
+func Sync(ctx context.Context, ag *glfs.Machine, dst schema.WO, src schema.RO, root glfs.Ref) error {
+	// missing maps each object which dst does not have to the objects it references.
+	missing := map[blobcache.CID][]glfs.Ref{}
+	seenObjects := map[blobcache.CID]struct{}{}
+	frontier := []glfs.Ref{root}
+	for len(frontier) > 0 {
+		var objects []glfs.Ref
+		for _, ref := range frontier {
+			if _, exists := seenObjects[ref.CID]; !exists {
+				seenObjects[ref.CID] = struct{}{}
+				objects = append(objects, ref)
+			}
+		}
+		have, err := exists(ctx, dst, refCIDs(objects))
+		if err != nil {
+			return err
+		}
+		var next []glfs.Ref
+		for i, ref := range objects {
+			if have[i] {
+				continue
+			}
+			children, err := ag.ChildRefs(ctx, src, ref)
+			if err != nil {
+				return err
+			}
+			missing[ref.CID] = children
+			next = append(next, children...)
+		}
+		frontier = next
+	}
+
+	// list the blocks in post order, so every block comes after the blocks it references.
+	var blocks []blobcache.CID
+	seenBlocks := map[blobcache.CID]struct{}{}
+	visited := map[blobcache.CID]struct{}{}
+	var visit func(ref glfs.Ref) error
+	visit = func(ref glfs.Ref) error {
+		children, isMissing := missing[ref.CID]
+		if _, yes := visited[ref.CID]; yes || !isMissing {
+			return nil
+		}
+		visited[ref.CID] = struct{}{}
+		for _, child := range children {
+			if err := visit(child); err != nil {
+				return err
+			}
+		}
+		return ag.TraverseBlocks(ctx, src, ref,
+			func(ctx context.Context, id blobcache.CID) (bool, error) {
+				_, exists := seenBlocks[id]
+				seenBlocks[id] = struct{}{}
+				return !exists, nil
+			},
+			func(ctx context.Context, b bigblob.Block) error {
+				blocks = append(blocks, b.Ref.CID)
+				return nil
+			},
+		)
+	}
+	if err := visit(root); err != nil {
+		return err
+	}
+	// objects which are missing can still share blocks with data which dst has.
+	have, err := exists(ctx, dst, blocks)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, src.MaxSize())
+	for i, cid := range blocks {
+		if have[i] {
+			continue
+		}
+		if err := copyBlock(ctx, dst, src, cid, buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyBlock(ctx context.Context, dst schema.WO, src schema.RO, cid blobcache.CID, buf []byte) error {
+	n, err := src.Get(ctx, cid, buf)
+	if err != nil {
+		return err
+	}
+	cid2, err := dst.Post(ctx, buf[:n])
+	if err != nil {
+		return err
+	}
+	if cid2 != cid {
+		return fmt.Errorf("stores have different hash functions. HAVE: %v WANT: %v", cid2, cid)
+	}
+	return nil
+}
+
+func exists(ctx context.Context, s schema.WO, cids []blobcache.CID) ([]bool, error) {
+	ret := make([]bool, len(cids))
+	if len(cids) == 0 {
+		return ret, nil
+	}
+	if err := s.Exists(ctx, cids, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func refCIDs(refs []glfs.Ref) []blobcache.CID {
+	ret := make([]blobcache.CID, len(refs))
+	for i, ref := range refs {
+		ret[i] = ref.CID
+	}
+	return ret
+}
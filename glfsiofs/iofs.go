@@ -6,7 +6,9 @@ import (
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"go.brendoncarroll.net/exp/streams"
@@ -15,61 +17,262 @@ import (
 	"blobcache.io/glfs"
 )
 
-var _ fs.FS = &FS{}
+// maxSymlinks is the number of symlinks which will be followed when resolving a path, before giving up.
+const maxSymlinks = 40
 
+var (
+	_ fs.FS         = &FS{}
+	_ fs.ReadFileFS = &FS{}
+	_ fs.StatFS     = &FS{}
+	_ fs.ReadDirFS  = &FS{}
+	_ fs.SubFS      = &FS{}
+	_ fs.GlobFS     = &FS{}
+	_ fs.ReadLinkFS = &FS{}
+)
+
+// FS is a read only io/fs.FS containing a glfs tree.
+// Symlinks are followed when opening files, as long as they do not leave the FS.
 type FS struct {
-	ag   *glfs.Machine
-	s    schema.RO
-	root glfs.Ref
+	ctx     context.Context
+	ag      *glfs.Machine
+	s       schema.RO
+	root    glfs.Ref
+	modTime time.Time
 }
 
+// New returns an FS for the tree at root, using the default Machine.
 func New(s schema.RO, root glfs.Ref) *FS {
+	return NewWithMachine(context.Background(), glfs.NewMachine(), s, root)
+}
+
+// NewWithMachine returns an FS for the tree at root.
+// ag is used to read from s, and ctx is used for all the operations on the FS and its Files.
+func NewWithMachine(ctx context.Context, ag *glfs.Machine, s schema.RO, root glfs.Ref) *FS {
 	return &FS{
-		ag:   glfs.NewMachine(),
+		ctx:  ctx,
+		ag:   ag,
 		s:    s,
 		root: root,
 	}
 }
 
+// NewFromCommit returns an FS for the root of the commit at c.
+// The commit's Time is used as the ModTime of every file.
+func NewFromCommit(ctx context.Context, ag *glfs.Machine, s schema.RO, c glfs.Ref) (*FS, error) {
+	commit, err := ag.GetCommit(ctx, s, c)
+	if err != nil {
+		return nil, err
+	}
+	fsys := NewWithMachine(ctx, ag, s, commit.Root)
+	fsys.modTime = commit.Time
+	return fsys, nil
+}
+
 func (s *FS) Open(p string) (fs.File, error) {
-	ctx := context.TODO()
-	if !fs.ValidPath(p) {
-		return nil, &fs.PathError{Path: p, Op: "open"}
+	ent, err := s.lookup("open", p, true)
+	if err != nil {
+		return nil, err
 	}
-	p = strings.Trim(p, "/")
-	if p == "." {
-		p = ""
+	return newGLFSFile(s.ctx, s.ag, s.s, *ent, s.modTime), nil
+}
+
+// ReadFile reads the whole file at p, without opening it.
+func (s *FS) ReadFile(p string) ([]byte, error) {
+	ent, err := s.lookup("readfile", p, true)
+	if err != nil {
+		return nil, err
+	}
+	if ent.Ref.Type == glfs.TypeTree {
+		return nil, &fs.PathError{Op: "readfile", Path: p, Err: errors.New("is a directory")}
+	}
+	return s.ag.GetBlobBytes(s.ctx, s.s, ent.Ref, int(ent.Ref.Size))
+}
+
+// Stat returns the FileInfo for p, from the entry in its parent tree.
+func (s *FS) Stat(p string) (fs.FileInfo, error) {
+	ent, err := s.lookup("stat", p, true)
+	if err != nil {
+		return nil, err
 	}
-	ent, err := s.ag.Lookup(ctx, s.s, glfs.TreeEntry{Ref: s.root}, p)
+	return newFileInfo(*ent, s.modTime), nil
+}
+
+// Lstat is like Stat, but does not follow a symlink at p.
+func (s *FS) Lstat(p string) (fs.FileInfo, error) {
+	ent, err := s.lookup("lstat", p, false)
 	if err != nil {
+		return nil, err
+	}
+	return newFileInfo(*ent, s.modTime), nil
+}
+
+// ReadDir returns the entries in the tree at p, in order, without opening it.
+func (s *FS) ReadDir(p string) ([]fs.DirEntry, error) {
+	ent, err := s.lookup("readdir", p, true)
+	if err != nil {
+		return nil, err
+	}
+	if ent.Ref.Type != glfs.TypeTree {
+		return nil, &fs.PathError{Op: "readdir", Path: p, Err: errors.New("not a directory")}
+	}
+	var ret []fs.DirEntry
+	for ent, err := range s.ag.Entries(s.ctx, s.s, ent.Ref) {
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, newDirEnt(ent, s.modTime))
+	}
+	return ret, nil
+}
+
+// Sub returns an FS for the tree at dir.
+func (s *FS) Sub(dir string) (fs.FS, error) {
+	ent, err := s.lookup("sub", dir, true)
+	if err != nil {
+		return nil, err
+	}
+	if ent.Ref.Type != glfs.TypeTree {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: errors.New("not a directory")}
+	}
+	s2 := *s
+	s2.root = ent.Ref
+	return &s2, nil
+}
+
+// Glob returns the paths matching pattern, using the same syntax as path.Match.
+// Trees which cannot contain a match are never read.
+func (s *FS) Glob(pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	// glfs.Glob treats "**" as matching any number of elements, but path.Match treats it the same as "*".
+	elems := strings.Split(pattern, "/")
+	for i := range elems {
+		if elems[i] == "**" {
+			elems[i] = "*"
+		}
+	}
+	var ret []string
+	for ent, err := range s.ag.Glob(s.ctx, s.s, s.root, strings.Join(elems, "/")) {
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, ent.Name)
+	}
+	return ret, nil
+}
+
+// ReadLink returns the target of the symlink at p.
+func (s *FS) ReadLink(p string) (string, error) {
+	ent, err := s.lookup("readlink", p, false)
+	if err != nil {
+		return "", err
+	}
+	if ent.FileMode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: p, Err: fs.ErrInvalid}
+	}
+	return s.readlink(ent.Ref)
+}
+
+func (s *FS) readlink(ref glfs.Ref) (string, error) {
+	data, err := s.ag.GetBlobBytes(s.ctx, s.s, ref, 4096)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// lookup returns the entry at p.
+// Symlinks are followed for every element of p except the last, which is only followed if follow is true.
+func (s *FS) lookup(op, p string, follow bool) (*glfs.TreeEntry, error) {
+	if !fs.ValidPath(p) {
+		return nil, &fs.PathError{Op: op, Path: p, Err: fs.ErrInvalid}
+	}
+	mkErr := func(err error) error {
 		if glfs.IsErrNoEnt(err) {
 			err = fs.ErrNotExist
 		}
-		return nil, err
+		return &fs.PathError{Op: op, Path: p, Err: err}
+	}
+	rest := p
+	if rest == "." {
+		rest = ""
 	}
-	return newGLFSFile(ctx, s.ag, s.s, *ent), nil
+	var dir string
+	ent := glfs.TreeEntry{Name: ".", FileMode: fs.ModeDir | 0o755, Ref: s.root}
+	for hops := 0; rest != ""; {
+		var elem string
+		elem, rest, _ = strings.Cut(rest, "/")
+		if ent.Ref.Type != glfs.TypeTree {
+			return nil, mkErr(fs.ErrNotExist)
+		}
+		ent2, err := s.ag.Lookup(s.ctx, s.s, ent, elem)
+		if err != nil {
+			return nil, mkErr(err)
+		}
+		ent = *ent2
+		if ent.FileMode&fs.ModeSymlink == 0 || (rest == "" && !follow) {
+			dir = path.Join(dir, elem)
+			continue
+		}
+		if hops++; hops > maxSymlinks {
+			return nil, mkErr(errors.New("too many levels of symbolic links"))
+		}
+		target, err := s.readlink(ent.Ref)
+		if err != nil {
+			return nil, mkErr(err)
+		}
+		if path.IsAbs(target) {
+			// the symlink leaves the FS
+			return nil, mkErr(fs.ErrNotExist)
+		}
+		target = path.Join(dir, target, rest)
+		if !fs.ValidPath(target) {
+			// the symlink leaves the FS
+			return nil, mkErr(fs.ErrNotExist)
+		}
+		rest, dir = target, ""
+		if rest == "." {
+			rest = ""
+		}
+		ent = glfs.TreeEntry{Name: ".", FileMode: fs.ModeDir | 0o755, Ref: s.root}
+	}
+	if p != "." {
+		ent.Name = path.Base(p)
+	}
+	return &ent, nil
 }
 
-var _ fs.File = &File{}
+var (
+	_ fs.File        = &File{}
+	_ fs.ReadDirFile = &File{}
+	_ io.ReaderAt    = &File{}
+	_ io.Seeker      = &File{}
+)
 
+// File is an open file or directory in an FS.
+// ReadAt is safe to call concurrently with other methods.
 type File struct {
-	ctx context.Context
-	ag  *glfs.Machine
-	s   schema.RO
+	ctx     context.Context
+	ag      *glfs.Machine
+	s       schema.RO
+	modTime time.Time
 
 	ref  glfs.Ref
 	name string
 	mode os.FileMode
 
+	mu sync.Mutex
 	r  *glfs.Reader
 	tr *glfs.TreeReader
 }
 
-func newGLFSFile(ctx context.Context, ag *glfs.Machine, s schema.RO, ent glfs.TreeEntry) *File {
+func newGLFSFile(ctx context.Context, ag *glfs.Machine, s schema.RO, ent glfs.TreeEntry, modTime time.Time) *File {
 	return &File{
-		ctx: ctx,
-		ag:  ag,
-		s:   s,
+		ctx:     ctx,
+		ag:      ag,
+		s:       s,
+		modTime: modTime,
 
 		name: ent.Name,
 		mode: ent.FileMode,
@@ -77,36 +280,55 @@ func newGLFSFile(ctx context.Context, ag *glfs.Machine, s schema.RO, ent glfs.Tr
 	}
 }
 
-func (f *File) Read(buf []byte) (int, error) {
+// reader returns the Reader for the file, creating it if necessary.
+// f.mu must be held.
+func (f *File) reader() (*glfs.Reader, error) {
 	if f.ref.Type == glfs.TypeTree {
-		return 0, fs.ErrInvalid
+		return nil, &fs.PathError{Op: "read", Path: f.name, Err: errors.New("is a directory")}
 	}
-	ctx := f.ctx
 	if f.r == nil {
-		r, err := f.ag.GetBlob(ctx, f.s, f.ref)
+		r, err := f.ag.GetBlob(f.ctx, f.s, f.ref)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
 		f.r = r
 	}
-	n, err := f.r.Read(buf)
-	return n, err
+	return f.r, nil
 }
 
-func (f *File) Seek(offset int64, whence int) (int64, error) {
-	return f.r.Seek(offset, whence)
+func (f *File) Read(buf []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, err := f.reader()
+	if err != nil {
+		return 0, err
+	}
+	return r.Read(buf)
 }
 
-func (f *File) Stat() (fs.FileInfo, error) {
-	finfo := &fileInfo{
-		name: f.name,
-		mode: f.mode,
-		size: int64(f.ref.Size),
+func (f *File) ReadAt(buf []byte, offset int64) (int, error) {
+	f.mu.Lock()
+	r, err := f.reader()
+	f.mu.Unlock()
+	if err != nil {
+		return 0, err
 	}
-	if f.ref.Type == glfs.TypeTree {
-		finfo.mode |= fs.ModeDir
+	// ReadAt does not use the Reader's offset, so it does not need the lock.
+	return r.ReadAt(buf, offset)
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r, err := f.reader()
+	if err != nil {
+		return 0, err
 	}
-	return finfo, nil
+	return r.Seek(offset, whence)
+}
+
+func (f *File) Stat() (fs.FileInfo, error) {
+	return newFileInfo(glfs.TreeEntry{Name: f.name, FileMode: f.mode, Ref: f.ref}, f.modTime), nil
 }
 
 func (f *File) Close() error {
@@ -114,7 +336,9 @@ func (f *File) Close() error {
 }
 
 func (f *File) ReadDir(n int) ([]fs.DirEntry, error) {
-	ctx := context.TODO()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ctx := f.ctx
 	if f.ref.Type != glfs.TypeTree {
 		return nil, errors.New("read on non-Tree")
 	}
@@ -128,11 +352,7 @@ func (f *File) ReadDir(n int) ([]fs.DirEntry, error) {
 	var ret []fs.DirEntry
 	if n <= 0 {
 		if err := streams.ForEach(ctx, f.tr, func(ent glfs.TreeEntry) error {
-			ret = append(ret, dirEnt{
-				name: ent.Name,
-				mode: ent.FileMode,
-				size: int64(ent.Ref.Size),
-			})
+			ret = append(ret, newDirEnt(ent, f.modTime))
 			return nil
 		}); err != nil {
 			return nil, err
@@ -147,11 +367,7 @@ func (f *File) ReadDir(n int) ([]fs.DirEntry, error) {
 			}
 			return nil, err
 		}
-		ret = append(ret, dirEnt{
-			name: ent.Name,
-			mode: ent.FileMode,
-			size: int64(ent.Ref.Size),
-		})
+		ret = append(ret, newDirEnt(ent, f.modTime))
 	}
 	return ret, nil
 }
@@ -163,6 +379,19 @@ type fileInfo struct {
 	size    int64
 }
 
+func newFileInfo(ent glfs.TreeEntry, modTime time.Time) fileInfo {
+	finfo := fileInfo{
+		name:    ent.Name,
+		mode:    ent.FileMode,
+		modTime: modTime,
+		size:    int64(ent.Ref.Size),
+	}
+	if ent.Ref.Type == glfs.TypeTree {
+		finfo.mode |= fs.ModeDir
+	}
+	return finfo
+}
+
 func (fi fileInfo) Name() string {
 	return fi.name
 }
@@ -188,27 +417,26 @@ func (fi fileInfo) Size() int64 {
 }
 
 type dirEnt struct {
-	name string
-	mode fs.FileMode
-	size int64
+	ent     glfs.TreeEntry
+	modTime time.Time
+}
+
+func newDirEnt(ent glfs.TreeEntry, modTime time.Time) dirEnt {
+	return dirEnt{ent: ent, modTime: modTime}
 }
 
 func (de dirEnt) Name() string {
-	return de.name
+	return de.ent.Name
 }
 
 func (de dirEnt) Info() (fs.FileInfo, error) {
-	return fileInfo{
-		name: de.name,
-		mode: de.mode,
-		size: de.size,
-	}, nil
+	return newFileInfo(de.ent, de.modTime), nil
 }
 
-func (dr dirEnt) IsDir() bool {
-	return dr.mode.IsDir()
+func (de dirEnt) IsDir() bool {
+	return de.ent.Ref.Type == glfs.TypeTree
 }
 
-func (dr dirEnt) Type() fs.FileMode {
-	return dr.mode & fs.ModeType
+func (de dirEnt) Type() fs.FileMode {
+	return newFileInfo(de.ent, de.modTime).mode & fs.ModeType
 }
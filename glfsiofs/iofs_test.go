@@ -3,9 +3,12 @@ package glfsiofs
 import (
 	"context"
 	"fmt"
+	"io"
+	"io/fs"
 	"path"
 	"testing"
 	"testing/fstest"
+	"time"
 
 	"blobcache.io/blobcache/src/blobcache"
 	"blobcache.io/blobcache/src/schema"
@@ -46,6 +49,95 @@ func TestFS(t *testing.T) {
 	}
 }
 
+func TestFSLinks(t *testing.T) {
+	ctx := context.TODO()
+	s := newStore()
+	ag := glfs.NewMachine()
+	link := func(name, target string) glfs.TreeEntry {
+		return glfs.TreeEntry{Name: name, FileMode: fs.ModeSymlink | 0o777, Ref: glfs.MustPostBlob(s, []byte(target))}
+	}
+	dir := glfs.MustPostTreeMap(s, map[string]glfs.Ref{
+		"a.txt": glfs.MustPostBlob(s, []byte("hello world")),
+		"b.txt": glfs.MustPostBlob(s, []byte("0123456789")),
+	})
+	ents := []glfs.TreeEntry{
+		{Name: "dir", FileMode: fs.ModeDir | 0o755, Ref: dir},
+		link("escape", "../outside"),
+		link("link-dir", "dir"),
+		link("link-file", "dir/a.txt"),
+	}
+	root := glfs.MustPostTreeSlice(s, ents)
+	c, err := ag.PostCommit(ctx, s, glfs.Commit{Root: root, Time: time.Unix(1000, 0).UTC()})
+	require.NoError(t, err)
+	fsys, err := NewFromCommit(ctx, ag, s, *c)
+	require.NoError(t, err)
+
+	data, err := fs.ReadFile(fsys, "link-file")
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(data))
+	data, err = fs.ReadFile(fsys, "link-dir/b.txt")
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", string(data))
+	_, err = fs.Stat(fsys, "escape")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	target, err := fs.ReadLink(fsys, "link-dir")
+	require.NoError(t, err)
+	require.Equal(t, "dir", target)
+	finfo, err := fs.Lstat(fsys, "link-file")
+	require.NoError(t, err)
+	require.NotZero(t, finfo.Mode()&fs.ModeSymlink)
+	require.Equal(t, time.Unix(1000, 0).UTC(), finfo.ModTime())
+
+	matches, err := fs.Glob(fsys, "*/*.txt")
+	require.NoError(t, err)
+	require.Equal(t, []string{"dir/a.txt", "dir/b.txt"}, matches)
+	matches, err = fs.Glob(fsys, "**")
+	require.NoError(t, err)
+	require.Equal(t, []string{"dir", "escape", "link-dir", "link-file"}, matches)
+
+	sub, err := fs.Sub(fsys, "dir")
+	require.NoError(t, err)
+	require.NoError(t, fstest.TestFS(sub, "a.txt", "b.txt"))
+
+	// Seek and ReadAt work before the first Read.
+	f, err := fsys.Open("dir/b.txt")
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.(io.Seeker).Seek(5, io.SeekStart)
+	require.NoError(t, err)
+	buf := make([]byte, 3)
+	_, err = f.(io.ReaderAt).ReadAt(buf, 1)
+	require.NoError(t, err)
+	require.Equal(t, "123", string(buf))
+	_, err = io.ReadFull(f, buf)
+	require.NoError(t, err)
+	require.Equal(t, "567", string(buf))
+}
+
+func TestFSAbsLink(t *testing.T) {
+	ctx := context.TODO()
+	s := newStore()
+	ag := glfs.NewMachine()
+	// sub/abs points outside the FS, even though sub/a.txt exists.
+	sub := glfs.MustPostTreeSlice(s, []glfs.TreeEntry{
+		{Name: "a.txt", FileMode: 0o644, Ref: glfs.MustPostBlob(s, []byte("hello world"))},
+		{Name: "abs", FileMode: fs.ModeSymlink | 0o777, Ref: glfs.MustPostBlob(s, []byte("/a.txt"))},
+	})
+	root := glfs.MustPostTreeSlice(s, []glfs.TreeEntry{
+		{Name: "sub", FileMode: fs.ModeDir | 0o755, Ref: sub},
+	})
+	c, err := ag.PostCommit(ctx, s, glfs.Commit{Root: root, Time: time.Unix(1000, 0).UTC()})
+	require.NoError(t, err)
+	fsys, err := NewFromCommit(ctx, ag, s, *c)
+	require.NoError(t, err)
+
+	_, err = fs.ReadFile(fsys, "sub/abs")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+	target, err := fs.ReadLink(fsys, "sub/abs")
+	require.NoError(t, err)
+	require.Equal(t, "/a.txt", target)
+}
+
 func listPaths(t testing.TB, s schema.RO, x glfs.Ref) (ret []string) {
 	ctx := context.TODO()
 	require.NoError(t, glfs.WalkTree(ctx, s, x, func(prefix string, tree glfs.TreeEntry) error {
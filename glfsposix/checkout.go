@@ -0,0 +1,339 @@
+package glfsposix
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"go.brendoncarroll.net/state/posixfs"
+	"golang.org/x/sync/semaphore"
+
+	"blobcache.io/blobcache/src/schema"
+	"blobcache.io/glfs"
+	"blobcache.io/glfs/internal/slices2"
+)
+
+// Chmoder is implemented by filesystems which can change the mode of a file.
+// Checkout uses it to apply modes exactly, and to existing files and directories.
+type Chmoder interface {
+	Chmod(p string, mode os.FileMode) error
+}
+
+// Lstater is implemented by filesystems which can stat a path without following a symlink.
+// Checkout uses it to find symlinks, and otherwise reads the directory containing the path.
+type Lstater interface {
+	Lstat(p string) (os.FileInfo, error)
+}
+
+// CheckoutOption configures Checkout
+type CheckoutOption func(*checkoutConfig)
+
+type checkoutConfig struct {
+	keepExtra bool
+}
+
+// KeepExtra causes Checkout to leave files which are not in the tree, instead of deleting them.
+func KeepExtra() CheckoutOption {
+	return func(c *checkoutConfig) {
+		c.keepExtra = true
+	}
+}
+
+// Checkout makes the file or directory at p in fsx match root.
+// Unlike Export, p does not have to be empty, or not exist.
+//
+// Only files whose contents or mode differ from the tree are written.
+// Files are written to a temporary file in the same directory, and then renamed into place,
+// so a file is either entirely the old version, or entirely the new version.
+// Paths which are not in the tree are deleted, unless KeepExtra is passed.
+//
+// Modes are applied to files and directories when they are created, subject to the umask.
+// If fsx implements Chmoder, then modes are applied exactly, and to existing files and directories as well.
+// Symlinks are always recreated.
+func Checkout(ctx context.Context, ag *glfs.Machine, sem *semaphore.Weighted, s schema.RO, root glfs.Ref, fsx posixfs.FS, p string, opts ...CheckoutOption) error {
+	var cfg checkoutConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	mode := os.FileMode(0o644)
+	if root.Type == glfs.TypeTree {
+		mode = os.ModeDir | 0o755
+	}
+	if parent := path.Dir(p); parent != "." && parent != "/" {
+		if err := posixfs.MkdirAll(fsx, parent, 0o755); err != nil {
+			return err
+		}
+	}
+	co := checkouter{ag: ag, sem: sem, s: s, fs: fsx, cfg: cfg}
+	return co.checkout(ctx, p, glfs.TreeEntry{Name: path.Base(p), FileMode: mode, Ref: root})
+}
+
+type checkouter struct {
+	ag  *glfs.Machine
+	sem *semaphore.Weighted
+	s   schema.RO
+	fs  posixfs.FS
+	cfg checkoutConfig
+}
+
+func (co *checkouter) checkout(ctx context.Context, p string, ent glfs.TreeEntry) error {
+	mode, exists, err := co.lstat(p)
+	if err != nil {
+		return err
+	}
+	var finfo os.FileInfo
+	switch {
+	case exists && mode&os.ModeSymlink != 0:
+		// symlinks are replaced, and never followed, so that nothing outside of p is changed.
+		if ent.Ref.Type == glfs.TypeTree && ent.FileMode&os.ModeSymlink == 0 {
+			if err := co.fs.Remove(p); err != nil {
+				return err
+			}
+		}
+	case exists:
+		if finfo, err = co.fs.Stat(p); err != nil {
+			return err
+		}
+	}
+	switch {
+	case ent.FileMode&os.ModeSymlink != 0:
+		return co.checkoutSymlink(ctx, p, ent)
+	case ent.Ref.Type == glfs.TypeTree:
+		return co.checkoutTree(ctx, p, ent, finfo)
+	case ent.Ref.Type == glfs.TypeBlob:
+		return co.checkoutBlob(ctx, p, ent, finfo)
+	default:
+		return fmt.Errorf("unrecognzied type %q", ent.Ref.Type)
+	}
+}
+
+func (co *checkouter) checkoutTree(ctx context.Context, p string, ent glfs.TreeEntry, finfo os.FileInfo) error {
+	perm := modePerm(ent, 0o755)
+	switch {
+	case finfo == nil:
+		if err := co.fs.Mkdir(p, perm); err != nil {
+			return err
+		}
+	case !finfo.IsDir():
+		if err := co.fs.Remove(p); err != nil {
+			return err
+		}
+		if err := co.fs.Mkdir(p, perm); err != nil {
+			return err
+		}
+	case finfo.Mode().Perm() != perm:
+		if chmoder, ok := co.fs.(Chmoder); ok {
+			if err := chmoder.Chmod(p, perm); err != nil {
+				return err
+			}
+		}
+	}
+
+	tree, err := co.ag.GetTreeSlice(ctx, co.s, ent.Ref, 1e6)
+	if err != nil {
+		return err
+	}
+	if err := slices2.ParForEach(ctx, co.sem, tree, func(ctx context.Context, x glfs.TreeEntry) error {
+		return co.checkout(ctx, path.Join(p, x.Name), x)
+	}); err != nil {
+		return err
+	}
+	if co.cfg.keepExtra {
+		return nil
+	}
+	dirEnts, err := posixfs.ReadDir(co.fs, p)
+	if err != nil {
+		return err
+	}
+	for _, dirEnt := range dirEnts {
+		if glfs.Lookup(tree, dirEnt.Name) != nil {
+			continue
+		}
+		if err := co.remove(path.Join(p, dirEnt.Name), dirEnt.Mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (co *checkouter) checkoutBlob(ctx context.Context, p string, ent glfs.TreeEntry, finfo os.FileInfo) error {
+	perm := modePerm(ent, 0o644)
+	chmoder, canChmod := co.fs.(Chmoder)
+	if finfo != nil && !finfo.IsDir() && finfo.Size() == int64(ent.Ref.Size) {
+		same, err := co.sameContent(ctx, p, ent.Ref)
+		if err != nil {
+			return err
+		}
+		switch {
+		case same && finfo.Mode().Perm() == perm:
+			return nil
+		case same && canChmod:
+			return chmoder.Chmod(p, perm)
+		}
+	}
+
+	tmp, err := co.tempPath(p)
+	if err != nil {
+		return err
+	}
+	f, err := co.fs.OpenFile(tmp, posixfs.O_CREATE|posixfs.O_EXCL|posixfs.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	defer co.fs.Remove(tmp)
+	defer f.Close()
+	r, err := co.ag.GetBlob(ctx, co.s, ent.Ref)
+	if err != nil {
+		return err
+	}
+	if err := writeSparse(f, r, int64(ent.Ref.Size)); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := co.replace(tmp, p); err != nil {
+		return err
+	}
+	if canChmod {
+		// the mode given to OpenFile could have been changed by the umask.
+		return chmoder.Chmod(p, perm)
+	}
+	return nil
+}
+
+func (co *checkouter) checkoutSymlink(ctx context.Context, p string, ent glfs.TreeEntry) error {
+	target, err := co.ag.GetBlobBytes(ctx, co.s, ent.Ref, 4096)
+	if err != nil {
+		return err
+	}
+	tmp, err := co.tempPath(p)
+	if err != nil {
+		return err
+	}
+	if err := co.fs.Symlink(string(target), tmp); err != nil {
+		return err
+	}
+	defer co.fs.Remove(tmp)
+	return co.replace(tmp, p)
+}
+
+// replace renames tmp to p.
+// If the rename fails and p is a directory, then the directory is deleted and the rename is retried.
+// Any other error is returned, and p is left as it was.
+func (co *checkouter) replace(tmp, p string) error {
+	err := co.fs.Rename(tmp, p)
+	if err == nil {
+		return nil
+	}
+	mode, exists, err2 := co.lstat(p)
+	if err2 != nil || !exists || !mode.IsDir() {
+		return err
+	}
+	if err := co.fs.Rmdir(p); err != nil {
+		return err
+	}
+	return co.fs.Rename(tmp, p)
+}
+
+// lstat returns the mode of p, without following a symlink at p.
+// exists is false if there is nothing at p.
+func (co *checkouter) lstat(p string) (mode os.FileMode, exists bool, _ error) {
+	var finfo os.FileInfo
+	var err error
+	switch lstater, ok := co.fs.(Lstater); {
+	case ok:
+		finfo, err = lstater.Lstat(p)
+	case path.Clean(p) == "." || path.Clean(p) == "/":
+		// the root does not have a parent to read.
+		finfo, err = co.fs.Stat(p)
+	default:
+		dir, name := path.Split(path.Clean(p))
+		ents, err := posixfs.ReadDir(co.fs, dir)
+		if err != nil {
+			if posixfs.IsErrNotExist(err) {
+				return 0, false, nil
+			}
+			return 0, false, err
+		}
+		for _, ent := range ents {
+			if ent.Name == name {
+				return ent.Mode, true, nil
+			}
+		}
+		return 0, false, nil
+	}
+	if err != nil {
+		if posixfs.IsErrNotExist(err) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	return finfo.Mode(), true, nil
+}
+
+// remove deletes the file or directory at p.
+func (co *checkouter) remove(p string, mode os.FileMode) error {
+	if mode.IsDir() {
+		return co.fs.Rmdir(p)
+	}
+	return co.fs.Remove(p)
+}
+
+// sameContent returns true if the file at p contains the same data as the blob at ref.
+func (co *checkouter) sameContent(ctx context.Context, p string, ref glfs.Ref) (bool, error) {
+	f, err := co.fs.OpenFile(p, posixfs.O_RDONLY, 0)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	r, err := co.ag.GetBlob(ctx, co.s, ref)
+	if err != nil {
+		return false, err
+	}
+	buf1 := make([]byte, 1<<16)
+	buf2 := make([]byte, 1<<16)
+	for {
+		n1, err1 := io.ReadFull(f, buf1)
+		if err1 != nil && !errors.Is(err1, io.ErrUnexpectedEOF) && !errors.Is(err1, io.EOF) {
+			return false, err1
+		}
+		n2, err2 := io.ReadFull(r, buf2)
+		if err2 != nil && !errors.Is(err2, io.ErrUnexpectedEOF) && !errors.Is(err2, io.EOF) {
+			return false, err2
+		}
+		if !bytes.Equal(buf1[:n1], buf2[:n2]) {
+			return false, nil
+		}
+		if err1 != nil || err2 != nil {
+			return err1 != nil && err2 != nil, nil
+		}
+	}
+}
+
+// tempPath returns a path for a temporary file next to p.
+func (co *checkouter) tempPath(p string) (string, error) {
+	var suffix [8]byte
+	if _, err := rand.Read(suffix[:]); err != nil {
+		return "", err
+	}
+	dir, name := path.Split(p)
+	return path.Join(dir, "."+name+".glfs-"+hex.EncodeToString(suffix[:])), nil
+}
+
+// modePerm returns the permission bits of the entry, or def if there are none.
+func modePerm(ent glfs.TreeEntry, def os.FileMode) os.FileMode {
+	if perm := ent.FileMode.Perm(); perm != 0 {
+		return perm
+	}
+	return def
+}
@@ -0,0 +1,127 @@
+package glfsposix
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"blobcache.io/blobcache/src/blobcache"
+	"blobcache.io/blobcache/src/schema"
+	"blobcache.io/glfs"
+	"github.com/stretchr/testify/require"
+	"go.brendoncarroll.net/state/posixfs"
+	"golang.org/x/sync/semaphore"
+)
+
+func TestCheckout(t *testing.T) {
+	ctx := context.Background()
+	ag := glfs.NewMachine()
+	s := schema.NewMem(blobcache.HashAlgo_BLAKE3_256.HashFunc(), glfs.DefaultBlockSize)
+	sem := semaphore.NewWeighted(4)
+	root, err := ag.PostTreeSlice(ctx, s, []glfs.TreeEntry{
+		{Name: "same.txt", FileMode: 0o644, Ref: mustPostBlob(t, ag, s, "same")},
+		{Name: "changed.txt", FileMode: 0o644, Ref: mustPostBlob(t, ag, s, "new contents")},
+		{Name: "script.sh", FileMode: 0o755, Ref: mustPostBlob(t, ag, s, "#!/bin/sh")},
+		{Name: "was-dir", FileMode: 0o644, Ref: mustPostBlob(t, ag, s, "now a file")},
+		{Name: "link", FileMode: os.ModeSymlink | 0o777, Ref: mustPostBlob(t, ag, s, "same.txt")},
+		{Name: "sub", FileMode: os.ModeDir | 0o755, Ref: glfs.MustPostTreeMap(s, map[string]glfs.Ref{
+			"a.txt": mustPostBlob(t, ag, s, "a"),
+		})},
+	})
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	fsx := posixfs.NewDirFS(dir)
+	put := func(p, data string) {
+		require.NoError(t, posixfs.MkdirAll(fsx, filepath.Dir(p), 0o755))
+		require.NoError(t, posixfs.PutFile(ctx, fsx, p, 0o644, strings.NewReader(data)))
+	}
+	put("same.txt", "same")
+	put("changed.txt", "old")
+	put("was-dir/x.txt", "x")
+	put("extra.txt", "extra")
+	put("sub/extra/y.txt", "y")
+	sameInfo, err := os.Stat(filepath.Join(dir, "same.txt"))
+	require.NoError(t, err)
+
+	require.NoError(t, Checkout(ctx, ag, sem, s, *root, fsx, "", KeepExtra()))
+	_, err = fsx.Stat("extra.txt")
+	require.NoError(t, err)
+
+	require.NoError(t, Checkout(ctx, ag, sem, s, *root, fsx, ""))
+	for p, expected := range map[string]string{
+		"same.txt":    "same",
+		"changed.txt": "new contents",
+		"was-dir":     "now a file",
+		"link":        "same",
+		"sub/a.txt":   "a",
+	} {
+		data, err := posixfs.ReadFile(ctx, fsx, p)
+		require.NoError(t, err)
+		require.Equal(t, expected, string(data), p)
+	}
+	ents, err := posixfs.ReadDir(fsx, "")
+	require.NoError(t, err)
+	require.Len(t, ents, 6)
+	_, err = fsx.Stat("sub/extra")
+	require.True(t, posixfs.IsErrNotExist(err))
+	finfo, err := fsx.Stat("script.sh")
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o755), finfo.Mode().Perm())
+	// the unchanged file was not rewritten.
+	finfo, err = os.Stat(filepath.Join(dir, "same.txt"))
+	require.NoError(t, err)
+	require.True(t, os.SameFile(sameInfo, finfo))
+	target, err := os.Readlink(filepath.Join(dir, "link"))
+	require.NoError(t, err)
+	require.Equal(t, "same.txt", target)
+
+	// checking out into a glfsposix.FS reproduces the tree exactly.
+	gfs, err := NewFS(ctx, ag, s, nil)
+	require.NoError(t, err)
+	require.NoError(t, Checkout(ctx, ag, sem, s, *root, gfs, ""))
+	root2, err := gfs.Commit(ctx)
+	require.NoError(t, err)
+	require.Equal(t, *root, *root2)
+}
+
+func TestCheckoutSymlinks(t *testing.T) {
+	ctx := context.Background()
+	ag := glfs.NewMachine()
+	s := schema.NewMem(blobcache.HashAlgo_BLAKE3_256.HashFunc(), glfs.DefaultBlockSize)
+	sem := semaphore.NewWeighted(4)
+	root, err := ag.PostTreeSlice(ctx, s, []glfs.TreeEntry{
+		{Name: "data", FileMode: os.ModeDir | 0o755, Ref: glfs.MustPostTreeMap(s, map[string]glfs.Ref{
+			"a.txt": mustPostBlob(t, ag, s, "a"),
+		})},
+		{Name: "file.txt", FileMode: 0o644, Ref: mustPostBlob(t, ag, s, "same")},
+		{Name: "same.txt", FileMode: 0o644, Ref: mustPostBlob(t, ag, s, "same")},
+	})
+	require.NoError(t, err)
+
+	outside := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(outside, "victim.txt"), []byte("victim"), 0o644))
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "same.txt"), []byte("same"), 0o644))
+	require.NoError(t, os.Symlink(outside, filepath.Join(dir, "data")))
+	require.NoError(t, os.Symlink("same.txt", filepath.Join(dir, "file.txt")))
+
+	require.NoError(t, Checkout(ctx, ag, sem, s, *root, posixfs.NewDirFS(dir), ""))
+	// nothing outside of the target was changed.
+	data, err := os.ReadFile(filepath.Join(outside, "victim.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "victim", string(data))
+	_, err = os.Stat(filepath.Join(outside, "a.txt"))
+	require.True(t, os.IsNotExist(err))
+	// the symlinks were replaced.
+	for _, p := range []string{"data", "file.txt"} {
+		finfo, err := os.Lstat(filepath.Join(dir, p))
+		require.NoError(t, err)
+		require.Zero(t, finfo.Mode()&os.ModeSymlink, p)
+	}
+	data, err = os.ReadFile(filepath.Join(dir, "data", "a.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "a", string(data))
+}
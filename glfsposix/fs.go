@@ -0,0 +1,691 @@
+package glfsposix
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.brendoncarroll.net/state/posixfs"
+
+	"blobcache.io/blobcache/src/schema"
+	"blobcache.io/glfs"
+)
+
+// maxSymlinks is the number of symlinks which will be followed when resolving a path, before giving up.
+const maxSymlinks = 40
+
+var (
+	_ posixfs.FS = &FS{}
+	_ Chmoder    = &FS{}
+	_ Lstater    = &FS{}
+)
+
+// FS is a writable posixfs.FS backed by a glfs tree.
+// Changes to the directory structure are buffered in memory.
+// The contents of a file are buffered in memory while it is open for writing, and written to the store when it is closed or synced.
+// Commit writes the modified trees to the store, and returns the new root.
+//
+// Unmodified directories are only read from the store when they are needed, and are reused by Ref when committing.
+// Symlinks are stored as blobs containing the target, with os.ModeSymlink set in the FileMode.
+type FS struct {
+	ctx context.Context
+	ag  *glfs.Machine
+	s   schema.RW
+
+	mu   sync.Mutex
+	root *node
+}
+
+// NewFS returns an FS containing the tree at root.
+// If root is nil, the FS starts empty.
+// Data is read from and written to s using ag.  ctx is used for all the operations on the FS.
+func NewFS(ctx context.Context, ag *glfs.Machine, s schema.RW, root *glfs.Ref) (*FS, error) {
+	fsx := &FS{ctx: ctx, ag: ag, s: s}
+	if root == nil {
+		fsx.root = &node{mode: fs.ModeDir | 0o755, children: map[string]*node{}, dirty: true}
+	} else {
+		if root.Type != glfs.TypeTree {
+			return nil, fmt.Errorf("root must be a tree, have %v", root.Type)
+		}
+		fsx.root = &node{mode: fs.ModeDir | 0o755, ref: *root}
+	}
+	return fsx, nil
+}
+
+// Commit writes all of the changes made to the FS, and returns the root of the new tree.
+// Files which are still open for writing are committed with the contents they had when they were last closed or synced.
+func (fsx *FS) Commit(ctx context.Context) (*glfs.Ref, error) {
+	fsx.mu.Lock()
+	defer fsx.mu.Unlock()
+	if err := fsx.commit(ctx, fsx.root); err != nil {
+		return nil, err
+	}
+	ref := fsx.root.ref
+	return &ref, nil
+}
+
+func (fsx *FS) commit(ctx context.Context, n *node) error {
+	if !n.dirty {
+		return nil
+	}
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	ents := make([]glfs.TreeEntry, 0, len(names))
+	for _, name := range names {
+		child := n.children[name]
+		if err := fsx.commit(ctx, child); err != nil {
+			return err
+		}
+		ents = append(ents, glfs.TreeEntry{
+			Name:     name,
+			FileMode: child.mode,
+			Ref:      child.ref,
+		})
+	}
+	ref, err := fsx.ag.PostTreeSlice(ctx, fsx.s, ents)
+	if err != nil {
+		return err
+	}
+	n.ref = *ref
+	n.dirty = false
+	return nil
+}
+
+func (fsx *FS) OpenFile(p string, flag int, perm os.FileMode) (posixfs.File, error) {
+	fsx.mu.Lock()
+	defer fsx.mu.Unlock()
+	writable := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	parent, name, n, err := fsx.resolve(p, true)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: p, Err: err}
+	}
+	switch {
+	case n == nil && flag&os.O_CREATE == 0:
+		return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+	case n == nil:
+		ref, err := fsx.ag.PostBlob(fsx.ctx, fsx.s, bytes.NewReader(nil))
+		if err != nil {
+			return nil, err
+		}
+		n = &node{mode: perm & fs.ModePerm, ref: *ref}
+		if err := fsx.put(parent, name, n); err != nil {
+			return nil, &fs.PathError{Op: "open", Path: p, Err: err}
+		}
+	case flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrExist}
+	case n.mode.IsDir() && writable:
+		return nil, &fs.PathError{Op: "open", Path: p, Err: syscall.EISDIR}
+	}
+
+	f := &file{fsx: fsx, n: n, name: name, flag: flag}
+	if writable {
+		f.data = []byte{}
+		if flag&os.O_TRUNC != 0 {
+			f.dirty = n.ref.Size > 0
+		} else if n.ref.Size > 0 {
+			data, err := fsx.ag.GetBlobBytes(fsx.ctx, fsx.s, n.ref, int(n.ref.Size))
+			if err != nil {
+				return nil, err
+			}
+			f.data = data
+		}
+	}
+	return f, nil
+}
+
+func (fsx *FS) Mkdir(p string, perm os.FileMode) error {
+	fsx.mu.Lock()
+	defer fsx.mu.Unlock()
+	parent, name, n, err := fsx.resolve(p, false)
+	if err != nil {
+		return &fs.PathError{Op: "mkdir", Path: p, Err: err}
+	}
+	if n != nil {
+		return &fs.PathError{Op: "mkdir", Path: p, Err: fs.ErrExist}
+	}
+	n = &node{mode: fs.ModeDir | perm&fs.ModePerm, children: map[string]*node{}, dirty: true}
+	if err := fsx.put(parent, name, n); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: p, Err: err}
+	}
+	return nil
+}
+
+// Rmdir removes the directory at p, and everything beneath it.
+// This matches the behavior of the OS backed posixfs.FS.
+func (fsx *FS) Rmdir(p string) error {
+	fsx.mu.Lock()
+	defer fsx.mu.Unlock()
+	parent, name, n, err := fsx.resolve(p, false)
+	if err != nil {
+		return &fs.PathError{Op: "rmdir", Path: p, Err: err}
+	}
+	switch {
+	case n == nil:
+		return nil
+	case parent == nil:
+		return &fs.PathError{Op: "rmdir", Path: p, Err: fs.ErrInvalid}
+	case !n.mode.IsDir():
+		return &fs.PathError{Op: "rmdir", Path: p, Err: syscall.ENOTDIR}
+	}
+	fsx.delete(parent, name)
+	return nil
+}
+
+// Remove removes the file or empty directory at p.
+func (fsx *FS) Remove(p string) error {
+	fsx.mu.Lock()
+	defer fsx.mu.Unlock()
+	parent, name, n, err := fsx.resolve(p, false)
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: p, Err: err}
+	}
+	switch {
+	case n == nil:
+		return &fs.PathError{Op: "remove", Path: p, Err: fs.ErrNotExist}
+	case parent == nil:
+		return &fs.PathError{Op: "remove", Path: p, Err: fs.ErrInvalid}
+	}
+	if n.mode.IsDir() {
+		children, err := fsx.children(n)
+		if err != nil {
+			return err
+		}
+		if len(children) > 0 {
+			return &fs.PathError{Op: "remove", Path: p, Err: syscall.ENOTEMPTY}
+		}
+	}
+	fsx.delete(parent, name)
+	return nil
+}
+
+// Rename moves the file or directory at oldPath to newPath.
+// If there is already a file at newPath it is replaced.
+// A directory can only replace an empty directory.
+func (fsx *FS) Rename(oldPath, newPath string) error {
+	fsx.mu.Lock()
+	defer fsx.mu.Unlock()
+	mkErr := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldPath, New: newPath, Err: err}
+	}
+	oldParent, oldName, n, err := fsx.resolve(oldPath, false)
+	if err != nil {
+		return mkErr(err)
+	}
+	if n == nil {
+		return mkErr(fs.ErrNotExist)
+	}
+	if oldParent == nil {
+		return mkErr(fs.ErrInvalid)
+	}
+	newParent, newName, existing, err := fsx.resolve(newPath, false)
+	if err != nil {
+		return mkErr(err)
+	}
+	if existing == n {
+		return nil
+	}
+	for x := newParent; x != nil; x = x.parent {
+		if x == n {
+			// cannot move a directory beneath itself
+			return mkErr(fs.ErrInvalid)
+		}
+	}
+	if existing != nil {
+		switch {
+		case n.mode.IsDir() && !existing.mode.IsDir():
+			return mkErr(syscall.ENOTDIR)
+		case !n.mode.IsDir() && existing.mode.IsDir():
+			return mkErr(syscall.EISDIR)
+		case existing.mode.IsDir():
+			children, err := fsx.children(existing)
+			if err != nil {
+				return err
+			}
+			if len(children) > 0 {
+				return mkErr(syscall.ENOTEMPTY)
+			}
+		}
+	}
+	fsx.delete(oldParent, oldName)
+	return fsx.put(newParent, newName, n)
+}
+
+func (fsx *FS) Stat(p string) (posixfs.FileInfo, error) {
+	fsx.mu.Lock()
+	defer fsx.mu.Unlock()
+	return fsx.stat("stat", p, true)
+}
+
+// Lstat is like Stat, but does not follow a symlink at p.
+func (fsx *FS) Lstat(p string) (posixfs.FileInfo, error) {
+	fsx.mu.Lock()
+	defer fsx.mu.Unlock()
+	return fsx.stat("lstat", p, false)
+}
+
+func (fsx *FS) stat(op, p string, follow bool) (posixfs.FileInfo, error) {
+	_, name, n, err := fsx.resolve(p, follow)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: p, Err: err}
+	}
+	if n == nil {
+		return nil, &fs.PathError{Op: op, Path: p, Err: fs.ErrNotExist}
+	}
+	return n.info(name, int64(n.ref.Size)), nil
+}
+
+// Chmod sets the permission bits of the file or directory at p.
+func (fsx *FS) Chmod(p string, mode os.FileMode) error {
+	fsx.mu.Lock()
+	defer fsx.mu.Unlock()
+	_, _, n, err := fsx.resolve(p, true)
+	if err != nil {
+		return &fs.PathError{Op: "chmod", Path: p, Err: err}
+	}
+	if n == nil {
+		return &fs.PathError{Op: "chmod", Path: p, Err: fs.ErrNotExist}
+	}
+	n.mode = n.mode&^fs.ModePerm | mode&fs.ModePerm
+	if n.parent != nil {
+		n.parent.markDirty()
+	}
+	return nil
+}
+
+// Symlink creates a symlink at link, which points to target.
+func (fsx *FS) Symlink(target, link string) error {
+	fsx.mu.Lock()
+	defer fsx.mu.Unlock()
+	parent, name, n, err := fsx.resolve(link, false)
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: err}
+	}
+	if n != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: fs.ErrExist}
+	}
+	ref, err := fsx.ag.PostBlob(fsx.ctx, fsx.s, strings.NewReader(target))
+	if err != nil {
+		return err
+	}
+	n = &node{mode: fs.ModeSymlink | 0o777, ref: *ref}
+	return fsx.put(parent, name, n)
+}
+
+// Readlink returns the target of the symlink at p.
+func (fsx *FS) Readlink(p string) (string, error) {
+	fsx.mu.Lock()
+	defer fsx.mu.Unlock()
+	_, _, n, err := fsx.resolve(p, false)
+	if err != nil {
+		return "", &fs.PathError{Op: "readlink", Path: p, Err: err}
+	}
+	if n == nil {
+		return "", &fs.PathError{Op: "readlink", Path: p, Err: fs.ErrNotExist}
+	}
+	if n.mode&fs.ModeSymlink == 0 {
+		return "", &fs.PathError{Op: "readlink", Path: p, Err: fs.ErrInvalid}
+	}
+	return fsx.readlink(n)
+}
+
+func (fsx *FS) readlink(n *node) (string, error) {
+	data, err := fsx.ag.GetBlobBytes(fsx.ctx, fsx.s, n.ref, 4096)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// resolve finds the node at p.
+// If the last element of p does not exist, then n will be nil, and parent and name will be where it would be.
+// Symlinks are followed for every element of p except the last, which is only followed if follow is true.
+func (fsx *FS) resolve(p string, follow bool) (parent *node, name string, n *node, _ error) {
+	p = glfs.CleanPath(p)
+	hops := 0
+	n = fsx.root
+	for p != "" {
+		var elem string
+		elem, p, _ = strings.Cut(p, "/")
+		if !n.mode.IsDir() {
+			return nil, "", nil, syscall.ENOTDIR
+		}
+		children, err := fsx.children(n)
+		if err != nil {
+			return nil, "", nil, err
+		}
+		parent, name, n = n, elem, children[elem]
+		if n == nil {
+			if p != "" {
+				return nil, "", nil, fs.ErrNotExist
+			}
+			break
+		}
+		if n.mode&fs.ModeSymlink != 0 && (p != "" || follow) {
+			if hops++; hops > maxSymlinks {
+				return nil, "", nil, syscall.ELOOP
+			}
+			target, err := fsx.readlink(n)
+			if err != nil {
+				return nil, "", nil, err
+			}
+			if !path.IsAbs(target) {
+				target = path.Join(parent.path(), target)
+			}
+			p = glfs.CleanPath(path.Join(target, p))
+			parent, name, n = nil, "", fsx.root
+		}
+	}
+	return parent, name, n, nil
+}
+
+// children returns the children of the directory n, loading them from the store if necessary.
+func (fsx *FS) children(n *node) (map[string]*node, error) {
+	if n.children != nil {
+		return n.children, nil
+	}
+	children := map[string]*node{}
+	for ent, err := range fsx.ag.Entries(fsx.ctx, fsx.s, n.ref) {
+		if err != nil {
+			return nil, err
+		}
+		mode := ent.FileMode
+		if ent.Ref.Type == glfs.TypeTree {
+			mode |= fs.ModeDir
+		}
+		children[ent.Name] = &node{
+			mode:   mode,
+			ref:    ent.Ref,
+			parent: n,
+			name:   ent.Name,
+		}
+	}
+	n.children = children
+	return children, nil
+}
+
+// put adds n to parent as name, replacing anything that was there.
+func (fsx *FS) put(parent *node, name string, n *node) error {
+	if parent == nil {
+		return fs.ErrInvalid
+	}
+	if !glfs.IsValidName(name) {
+		return fs.ErrInvalid
+	}
+	children, err := fsx.children(parent)
+	if err != nil {
+		return err
+	}
+	children[name] = n
+	n.parent, n.name = parent, name
+	parent.markDirty()
+	return nil
+}
+
+func (fsx *FS) delete(parent *node, name string) {
+	if n := parent.children[name]; n != nil {
+		n.parent = nil
+	}
+	delete(parent.children, name)
+	parent.markDirty()
+}
+
+type node struct {
+	mode os.FileMode
+	// ref is the object for this node.  It is only valid for directories if dirty is false.
+	ref glfs.Ref
+	// dirty is true for directories whose children have changed since ref was written.
+	dirty bool
+	// children is nil until the directory has been read from the store.
+	children map[string]*node
+
+	parent *node
+	name   string
+}
+
+func (n *node) markDirty() {
+	for x := n; x != nil && !x.dirty; x = x.parent {
+		x.dirty = true
+	}
+}
+
+func (n *node) path() string {
+	if n.parent == nil {
+		return ""
+	}
+	return path.Join(n.parent.path(), n.name)
+}
+
+func (n *node) info(name string, size int64) fileInfo {
+	if n.mode.IsDir() {
+		size = 0
+	}
+	return fileInfo{name: name, mode: n.mode, size: size}
+}
+
+var _ posixfs.File = &file{}
+
+type file struct {
+	fsx  *FS
+	n    *node
+	name string
+	flag int
+
+	// r is used to read files which are not writable.
+	r *glfs.Reader
+	// data is the contents of a writable file.
+	data   []byte
+	offset int64
+	dirty  bool
+
+	dirEnts []posixfs.DirEnt
+	closed  bool
+}
+
+func (f *file) Read(buf []byte) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.n.mode.IsDir() {
+		return 0, syscall.EISDIR
+	}
+	if f.flag&os.O_WRONLY != 0 {
+		return 0, fs.ErrPermission
+	}
+	if f.data != nil {
+		if f.offset >= int64(len(f.data)) {
+			return 0, io.EOF
+		}
+		n := copy(buf, f.data[f.offset:])
+		f.offset += int64(n)
+		return n, nil
+	}
+	if f.r == nil {
+		f.fsx.mu.Lock()
+		ref := f.n.ref
+		f.fsx.mu.Unlock()
+		r, err := f.fsx.ag.GetBlob(f.fsx.ctx, f.fsx.s, ref)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := r.Seek(f.offset, io.SeekStart); err != nil {
+			return 0, err
+		}
+		f.r = r
+	}
+	n, err := f.r.Read(buf)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *file) Write(buf []byte) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	if f.data == nil {
+		return 0, fs.ErrPermission
+	}
+	if f.flag&os.O_APPEND != 0 {
+		f.offset = int64(len(f.data))
+	}
+	if end := f.offset + int64(len(buf)); end > int64(len(f.data)) {
+		f.data = append(f.data, make([]byte, end-int64(len(f.data)))...)
+	}
+	n := copy(f.data[f.offset:], buf)
+	f.offset += int64(n)
+	f.dirty = true
+	return n, nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	var size int64
+	if f.data != nil {
+		size = int64(len(f.data))
+	} else {
+		size = int64(f.n.ref.Size)
+	}
+	var next int64
+	switch whence {
+	case io.SeekStart:
+		next = offset
+	case io.SeekCurrent:
+		next = f.offset + offset
+	case io.SeekEnd:
+		next = size + offset
+	default:
+		return 0, fmt.Errorf("unsupported whence %d", whence)
+	}
+	if next < 0 {
+		return 0, fs.ErrInvalid
+	}
+	if f.r != nil {
+		if _, err := f.r.Seek(next, io.SeekStart); err != nil {
+			return 0, err
+		}
+	}
+	f.offset = next
+	return next, nil
+}
+
+func (f *file) Stat() (posixfs.FileInfo, error) {
+	if f.closed {
+		return nil, fs.ErrClosed
+	}
+	f.fsx.mu.Lock()
+	defer f.fsx.mu.Unlock()
+	size := int64(f.n.ref.Size)
+	if f.data != nil {
+		size = int64(len(f.data))
+	}
+	return f.n.info(f.name, size), nil
+}
+
+func (f *file) ReadDir(n int) ([]posixfs.DirEnt, error) {
+	if f.closed {
+		return nil, fs.ErrClosed
+	}
+	if !f.n.mode.IsDir() {
+		return nil, syscall.ENOTDIR
+	}
+	if f.dirEnts == nil {
+		f.fsx.mu.Lock()
+		children, err := f.fsx.children(f.n)
+		if err != nil {
+			f.fsx.mu.Unlock()
+			return nil, err
+		}
+		f.dirEnts = make([]posixfs.DirEnt, 0, len(children))
+		for name, child := range children {
+			f.dirEnts = append(f.dirEnts, posixfs.DirEnt{Name: name, Mode: child.mode})
+		}
+		f.fsx.mu.Unlock()
+		slices.SortFunc(f.dirEnts, func(a, b posixfs.DirEnt) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+	}
+	if n > 0 && len(f.dirEnts) == 0 {
+		return nil, io.EOF
+	}
+	if n <= 0 || n > len(f.dirEnts) {
+		n = len(f.dirEnts)
+	}
+	ret := f.dirEnts[:n]
+	f.dirEnts = f.dirEnts[n:]
+	return ret, nil
+}
+
+// Sync writes the contents of the file to the store.
+func (f *file) Sync() error {
+	if f.closed {
+		return fs.ErrClosed
+	}
+	if !f.dirty {
+		return nil
+	}
+	ref, err := f.fsx.ag.PostBlob(f.fsx.ctx, f.fsx.s, bytes.NewReader(f.data))
+	if err != nil {
+		return err
+	}
+	f.fsx.mu.Lock()
+	defer f.fsx.mu.Unlock()
+	f.n.ref = *ref
+	if f.n.parent != nil {
+		f.n.parent.markDirty()
+	}
+	f.dirty = false
+	return nil
+}
+
+func (f *file) Close() error {
+	if f.closed {
+		return nil
+	}
+	err := f.Sync()
+	f.closed = true
+	return err
+}
+
+type fileInfo struct {
+	name string
+	mode fs.FileMode
+	size int64
+}
+
+func (fi fileInfo) Name() string {
+	return fi.name
+}
+
+func (fi fileInfo) Mode() fs.FileMode {
+	return fi.mode
+}
+
+// ModTime always returns the zero time, glfs does not store modification times.
+func (fi fileInfo) ModTime() time.Time {
+	return time.Time{}
+}
+
+func (fi fileInfo) IsDir() bool {
+	return fi.mode.IsDir()
+}
+
+func (fi fileInfo) Sys() any {
+	return nil
+}
+
+func (fi fileInfo) Size() int64 {
+	return fi.size
+}
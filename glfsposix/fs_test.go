@@ -0,0 +1,121 @@
+package glfsposix
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"blobcache.io/blobcache/src/blobcache"
+	"blobcache.io/blobcache/src/schema"
+	"blobcache.io/glfs"
+	"github.com/stretchr/testify/require"
+	"go.brendoncarroll.net/state/posixfs"
+	"golang.org/x/sync/semaphore"
+)
+
+func TestFS(t *testing.T) {
+	ctx := context.Background()
+	ag := glfs.NewMachine()
+	s := schema.NewMem(blobcache.HashAlgo_BLAKE3_256.HashFunc(), glfs.DefaultBlockSize)
+	fsx, err := NewFS(ctx, ag, s, nil)
+	require.NoError(t, err)
+
+	require.NoError(t, posixfs.MkdirAll(fsx, "a/b", 0o755))
+	require.NoError(t, posixfs.PutFile(ctx, fsx, "a/b/c.txt", 0o644, strings.NewReader("hello")))
+	require.NoError(t, posixfs.AppendFile(ctx, fsx, "a/b/c.txt", 0o644, []byte(" world")))
+	require.NoError(t, posixfs.PutFile(ctx, fsx, "tmp.txt", 0o600, strings.NewReader("temporary")))
+	require.NoError(t, fsx.Symlink("b/c.txt", "a/link"))
+	require.NoError(t, fsx.Rename("a/b", "a/d"))
+	require.NoError(t, fsx.Remove("tmp.txt"))
+
+	_, err = fsx.OpenFile("a/d/c.txt", posixfs.O_CREATE|posixfs.O_EXCL|posixfs.O_WRONLY, 0o644)
+	require.True(t, posixfs.IsErrExist(err))
+	require.Error(t, fsx.Remove("a"))
+	_, err = fsx.Stat("a/b/c.txt")
+	require.True(t, posixfs.IsErrNotExist(err))
+
+	// the symlink is dangling after the rename
+	_, err = fsx.Stat("a/link")
+	require.True(t, posixfs.IsErrNotExist(err))
+	require.NoError(t, fsx.Remove("a/link"))
+	require.NoError(t, fsx.Symlink("d/c.txt", "a/link"))
+	data, err := posixfs.ReadFile(ctx, fsx, "a/link")
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(data))
+	finfo, err := fsx.Lstat("a/link")
+	require.NoError(t, err)
+	require.NotZero(t, finfo.Mode()&os.ModeSymlink)
+
+	root, err := fsx.Commit(ctx)
+	require.NoError(t, err)
+	ref, err := ag.GetAtPath(ctx, s, *root, "a/d/c.txt")
+	require.NoError(t, err)
+	data, err = ag.GetBlobBytes(ctx, s, *ref, 100)
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(data))
+	ents, err := ag.GetTreeSlice(ctx, s, *root, 10)
+	require.NoError(t, err)
+	require.Len(t, ents, 1)
+
+	// the tree can be exported to, and imported from a real filesystem.
+	osfs := posixfs.NewTestFS(t)
+	sem := semaphore.NewWeighted(1)
+	require.NoError(t, Export(ctx, ag, sem, s, *ref, osfs, "c.txt"))
+	ref2, err := Import(ctx, ag, sem, s, osfs, "c.txt")
+	require.NoError(t, err)
+	require.Equal(t, *ref, *ref2)
+}
+
+func TestFSModify(t *testing.T) {
+	ctx := context.Background()
+	ag := glfs.NewMachine()
+	s := schema.NewMem(blobcache.HashAlgo_BLAKE3_256.HashFunc(), glfs.DefaultBlockSize)
+	base, err := ag.PostTreeMap(ctx, s, map[string]glfs.Ref{
+		"x/1.txt": mustPostBlob(t, ag, s, "one"),
+		"y/2.txt": mustPostBlob(t, ag, s, "two"),
+	})
+	require.NoError(t, err)
+	x, err := ag.GetAtPath(ctx, s, *base, "x")
+	require.NoError(t, err)
+
+	fsx, err := NewFS(ctx, ag, s, base)
+	require.NoError(t, err)
+	// nothing has changed, the root is the same.
+	root, err := fsx.Commit(ctx)
+	require.NoError(t, err)
+	require.Equal(t, *base, *root)
+
+	f, err := fsx.OpenFile("y/2.txt", posixfs.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.Seek(1, io.SeekStart)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("ooo"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	require.NoError(t, fsx.Rmdir("nothing"))
+
+	root, err = fsx.Commit(ctx)
+	require.NoError(t, err)
+	ref, err := ag.GetAtPath(ctx, s, *root, "y/2.txt")
+	require.NoError(t, err)
+	data, err := ag.GetBlobBytes(ctx, s, *ref, 100)
+	require.NoError(t, err)
+	require.Equal(t, "tooo", string(data))
+	// the unmodified directory is reused.
+	x2, err := ag.GetAtPath(ctx, s, *root, "x")
+	require.NoError(t, err)
+	require.Equal(t, *x, *x2)
+
+	ents, err := posixfs.ReadDir(fsx, "")
+	require.NoError(t, err)
+	require.Len(t, ents, 2)
+	require.True(t, ents[0].Mode.IsDir())
+}
+
+func mustPostBlob(t testing.TB, ag *glfs.Machine, s schema.WO, data string) glfs.Ref {
+	ref, err := ag.PostBlob(context.TODO(), s, strings.NewReader(data))
+	require.NoError(t, err)
+	return *ref
+}
@@ -2,9 +2,13 @@ package glfsposix
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"path"
+	"slices"
+	"sync/atomic"
+	"time"
 
 	"go.brendoncarroll.net/state/posixfs"
 	"golang.org/x/sync/semaphore"
@@ -15,15 +19,54 @@ import (
 )
 
 // Import goes from a POSIX filesystem to GLFS
-func Import(ctx context.Context, ag *glfs.Machine, sem *semaphore.Weighted, s schema.WO, fsx posixfs.FS, p string) (*glfs.Ref, error) {
-	return glfsImport(ctx, glfsImportParams{
+func Import(ctx context.Context, ag *glfs.Machine, sem *semaphore.Weighted, s schema.WO, fsx posixfs.FS, p string, opts ...ImportOption) (*glfs.Ref, error) {
+	ig := newIgnorer(newImportConfig(opts))
+	ref, _, err := glfsImport(ctx, glfsImportParams{
 		ag:  ag,
 		sem: sem,
 		s:   s,
 
 		fs:     fsx,
 		target: p,
+		ignore: ig,
 	})
+	if err != nil {
+		return nil, err
+	}
+	ig.finish()
+	return ref, nil
+}
+
+// ImportIncremental is like Import, but uses the StatCache from a previous import to avoid reading files which have not changed.
+// A file is unchanged if its mode, size, modification time, change time, and inode are the same as in the cache.
+// Unchanged files are not opened, and directories where nothing has changed are reused by Ref.
+//
+// prev is the root returned by the previous import, the cache is only used if it was produced by that import.
+// The Refs in the cache must already exist in s.
+// If cache is nil, or prev is nil, everything is imported.
+// The returned StatCache should be passed to the next call.
+func ImportIncremental(ctx context.Context, ag *glfs.Machine, sem *semaphore.Weighted, s schema.WO, fsx posixfs.FS, p string, prev *glfs.Ref, cache *StatCache, opts ...ImportOption) (*glfs.Ref, *StatCache, error) {
+	start := time.Now().Truncate(time.Second)
+	ig := newIgnorer(newImportConfig(opts))
+	sc := &statCacheState{next: map[string]StatEntry{}}
+	if prev != nil && cache != nil && cache.Root.Equals(*prev) {
+		sc.prev = cache
+	}
+	ref, _, err := glfsImport(ctx, glfsImportParams{
+		ag:  ag,
+		sem: sem,
+		s:   s,
+
+		fs:     fsx,
+		target: p,
+		cache:  sc,
+		ignore: ig,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	ig.finish()
+	return ref, &StatCache{Root: *ref, Time: start, Entries: sc.next}, nil
 }
 
 type glfsImportParams struct {
@@ -33,31 +76,50 @@ type glfsImportParams struct {
 
 	fs     posixfs.FS
 	target string
+
+	// cache is nil unless the import is incremental.
+	cache *statCacheState
+	// rel is target, relative to the path being imported.
+	rel string
+	// ignore is nil if nothing is ignored.
+	ignore *ignorer
 }
 
-func glfsImport(ctx context.Context, p glfsImportParams) (*glfs.Ref, error) {
+// glfsImport imports the file or directory at p.target.
+// reused is true if the Ref came from the cache.
+func glfsImport(ctx context.Context, p glfsImportParams) (_ *glfs.Ref, reused bool, _ error) {
 	finfo, err := p.fs.Stat(p.target)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
+	st := newStatEntry(finfo)
+	prev, cached := p.cache.lookup(p.rel)
 	// dir
 	if finfo.IsDir() {
 		ents, err := posixfs.ReadDir(p.fs, p.target)
 		if err != nil {
-			return nil, err
+			return nil, false, err
+		}
+		ig, err := p.ignore.enter(p.fs, p.target, p.rel, ents)
+		if err != nil {
+			return nil, false, err
 		}
+		ents = slices.DeleteFunc(ents, func(ent posixfs.DirEnt) bool {
+			return ig.ignored(path.Join(p.rel, ent.Name), ent.Mode.IsDir())
+		})
+		var changed atomic.Bool
 		tents, err := slices2.ParMapErr(ctx, p.sem, ents, func(ctx context.Context, ent posixfs.DirEnt) (glfs.TreeEntry, error) {
-			p2 := path.Join(p.target, ent.Name)
-			ref2, err := glfsImport(ctx, glfsImportParams{
-				ag:     p.ag,
-				s:      p.s,
-				sem:    p.sem,
-				fs:     p.fs,
-				target: p2,
-			})
+			p2 := p
+			p2.ignore = ig
+			p2.target = path.Join(p.target, ent.Name)
+			p2.rel = path.Join(p.rel, ent.Name)
+			ref2, reused, err := glfsImport(ctx, p2)
 			if err != nil {
 				return glfs.TreeEntry{}, err
 			}
+			if !reused {
+				changed.Store(true)
+			}
 			return glfs.TreeEntry{
 				Name:     ent.Name,
 				FileMode: ent.Mode,
@@ -65,17 +127,111 @@ func glfsImport(ctx context.Context, p glfsImportParams) (*glfs.Ref, error) {
 			}, nil
 		})
 		if err != nil {
-			return nil, err
+			return nil, false, err
+		}
+		// every child was in the cache, and there are no new children, so the tree is the same.
+		if cached && prev.Mode == st.Mode && prev.Ref.Type == glfs.TypeTree && !changed.Load() && prev.Children == len(ents) {
+			st.Ref, st.Children = prev.Ref, len(ents)
+			p.cache.put(p.rel, st)
+			return &st.Ref, true, nil
+		}
+		ref, err := p.ag.PostTreeSlice(ctx, p.s, tents)
+		if err != nil {
+			return nil, false, err
 		}
-		return p.ag.PostTreeSlice(ctx, p.s, tents)
+		st.Ref, st.Children = *ref, len(ents)
+		p.cache.put(p.rel, st)
+		return ref, false, nil
 	}
 	// regular file
+	if cached && prev.sameFile(st) && prev.Ref.Type == glfs.TypeBlob {
+		st.Ref = prev.Ref
+		p.cache.put(p.rel, st)
+		return &st.Ref, true, nil
+	}
 	f, err := p.fs.OpenFile(p.target, posixfs.O_RDONLY, 0)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	defer f.Close()
-	return p.ag.PostBlob(ctx, p.s, f)
+	tw := p.ag.NewTypedWriter(p.s, glfs.TypeBlob)
+	tw.SetWriteContext(ctx)
+	if err := readSparse(tw, f, finfo.Size()); err != nil {
+		return nil, false, err
+	}
+	ref, err := tw.Finish(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+	st.Ref = *ref
+	p.cache.put(p.rel, st)
+	return ref, false, nil
+}
+
+// readSparse copies size bytes from f to tw.
+// Holes in f are written to tw as zeros, without reading them.
+func readSparse(tw *glfs.TypedWriter, f posixfs.File, size int64) error {
+	var offset int64
+	for offset < size {
+		start, end, err := nextData(f, offset, size)
+		if err != nil {
+			return err
+		}
+		if start > offset {
+			if err := tw.WriteZeros(uint64(start - offset)); err != nil {
+				return err
+			}
+		}
+		if start >= size {
+			break
+		}
+		if _, err := f.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(tw, f, end-start); err != nil {
+			return err
+		}
+		offset = end
+	}
+	return nil
+}
+
+// writeSparse copies the blob in r to f, leaving holes in f where there are holes in r.
+func writeSparse(f posixfs.File, r *glfs.Reader, size int64) error {
+	var offset int64
+	for offset < size {
+		start, err := r.Seek(offset, glfs.SeekData)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+		end, err := r.Seek(start, glfs.SeekHole)
+		if err != nil {
+			return err
+		}
+		if _, err := r.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := f.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(f, r, end-start); err != nil {
+			return err
+		}
+		offset = end
+	}
+	if offset < size {
+		// the file ends with a hole, extend it to the correct size.
+		if _, err := f.Seek(size-1, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := f.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Export exports a glfs object beneath p in the filesystem fsx.
@@ -120,10 +276,9 @@ func glfsExport(ctx context.Context, p glfsExportParams) error {
 			p2 := p
 			p2.target = path.Join(p.target, x.Name)
 			p2.ref = x.Ref
-			// p2.fileMode = x.FileMode
-			p2.fileMode = 0o644
+			p2.fileMode = modePerm(x, 0o644)
 			if p2.ref.Type == glfs.TypeTree {
-				p2.fileMode = 0o755
+				p2.fileMode = modePerm(x, 0o755)
 			}
 			return glfsExport(ctx, p2)
 		})
@@ -137,7 +292,7 @@ func glfsExport(ctx context.Context, p glfsExportParams) error {
 		if err != nil {
 			return err
 		}
-		if _, err = io.Copy(f, r); err != nil {
+		if err := writeSparse(f, r, int64(p.ref.Size)); err != nil {
 			return err
 		}
 		return f.Close()
@@ -2,8 +2,16 @@ package glfsposix
 
 import (
 	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"blobcache.io/blobcache/src/blobcache"
 	"blobcache.io/blobcache/src/schema"
@@ -47,3 +55,137 @@ func TestExport(t *testing.T) {
 	err = Export(ctx, op, sem, s, *ref, fs, "export_root")
 	require.NoError(t, err)
 }
+
+func TestSparse(t *testing.T) {
+	ctx := context.Background()
+	ag := glfs.NewMachine()
+	s := schema.NewMem(blobcache.HashAlgo_BLAKE3_256.HashFunc(), glfs.DefaultBlockSize)
+	fsx := posixfs.NewTestFS(t)
+	sem := semaphore.NewWeighted(1)
+
+	// a file with data at the start and end, and a large hole in the middle
+	const size = 10 * glfs.DefaultBlockSize
+	f, err := fsx.OpenFile("sparse.img", posixfs.O_CREATE|posixfs.O_WRONLY, 0o644)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("start"))
+	require.NoError(t, err)
+	_, err = f.Seek(size-3, io.SeekStart)
+	require.NoError(t, err)
+	_, err = f.Write([]byte("end"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	ref, err := Import(ctx, ag, sem, s, fsx, "sparse.img")
+	require.NoError(t, err)
+	require.Equal(t, uint64(size), ref.Size)
+	// 2 data blocks and the index, the holes are not stored.
+	require.Equal(t, 3, s.Len())
+
+	require.NoError(t, Export(ctx, ag, sem, s, *ref, fsx, "exported.img"))
+	f, err = fsx.OpenFile("exported.img", posixfs.O_RDONLY, 0)
+	require.NoError(t, err)
+	defer f.Close()
+	actual, err := io.ReadAll(f)
+	require.NoError(t, err)
+	expected := make([]byte, size)
+	copy(expected, "start")
+	copy(expected[size-3:], "end")
+	require.Equal(t, expected, actual)
+}
+
+func TestImportIncremental(t *testing.T) {
+	ctx := context.Background()
+	ag := glfs.NewMachine()
+	s := schema.NewMem(blobcache.HashAlgo_BLAKE3_256.HashFunc(), glfs.DefaultBlockSize)
+	sem := semaphore.NewWeighted(4)
+	dir := t.TempDir()
+	fsx := &openCounter{FS: posixfs.NewDirFS(dir)}
+	put := func(p, data string) {
+		require.NoError(t, posixfs.MkdirAll(fsx, path.Dir(p), 0o755))
+		require.NoError(t, posixfs.PutFile(ctx, fsx, p, 0o644, strings.NewReader(data)))
+		// files modified in the same second as an import are not trusted.
+		past := time.Now().Add(-time.Minute)
+		require.NoError(t, os.Chtimes(filepath.Join(dir, p), past, past))
+	}
+	put("a.txt", "a")
+	put("x/b.txt", "b")
+	put("y/c.txt", "c")
+	put("y/z/d.txt", "d")
+
+	root1, cache, err := ImportIncremental(ctx, ag, sem, s, fsx, "", nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"a.txt", "x/b.txt", "y/c.txt", "y/z/d.txt"}, fsx.files())
+	ref, err := Import(ctx, ag, sem, s, posixfs.NewDirFS(dir), "")
+	require.NoError(t, err)
+	require.Equal(t, *ref, *root1)
+
+	// the cache survives being persisted.
+	data, err := json.Marshal(cache)
+	require.NoError(t, err)
+	cache = &StatCache{}
+	require.NoError(t, json.Unmarshal(data, cache))
+
+	// nothing has changed
+	fsx.reset()
+	root2, cache, err := ImportIncremental(ctx, ag, sem, s, fsx, "", root1, cache)
+	require.NoError(t, err)
+	require.Equal(t, *root1, *root2)
+	require.Empty(t, fsx.files())
+
+	// only the changed file is read
+	fsx.reset()
+	put("y/z/d.txt", "dddd")
+	put("x/new.txt", "new")
+	root3, cache, err := ImportIncremental(ctx, ag, sem, s, fsx, "", root2, cache)
+	require.NoError(t, err)
+	require.Equal(t, []string{"x/new.txt", "y/z/d.txt"}, fsx.files())
+	ref, err = Import(ctx, ag, sem, s, posixfs.NewDirFS(dir), "")
+	require.NoError(t, err)
+	require.Equal(t, *ref, *root3)
+
+	// a cache for a different root is ignored
+	require.Equal(t, *root3, cache.Root)
+	fsx.reset()
+	_, _, err = ImportIncremental(ctx, ag, sem, s, fsx, "", root1, cache)
+	require.NoError(t, err)
+	require.Len(t, fsx.files(), 5)
+}
+
+// openCounter records the files and directories which are opened for reading.
+type openCounter struct {
+	posixfs.FS
+
+	mu     sync.Mutex
+	opened []string
+	dirs   []string
+}
+
+func (oc *openCounter) OpenFile(p string, flag int, perm os.FileMode) (posixfs.File, error) {
+	f, err := oc.FS.OpenFile(p, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if finfo, err := f.Stat(); err == nil && flag == posixfs.O_RDONLY {
+		oc.mu.Lock()
+		if finfo.IsDir() {
+			oc.dirs = append(oc.dirs, p)
+		} else {
+			oc.opened = append(oc.opened, p)
+		}
+		oc.mu.Unlock()
+	}
+	return f, nil
+}
+
+func (oc *openCounter) files() []string {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	return slices.Sorted(slices.Values(oc.opened))
+}
+
+func (oc *openCounter) reset() {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+	oc.opened = nil
+	oc.dirs = nil
+}
@@ -0,0 +1,273 @@
+package glfsposix
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.brendoncarroll.net/state/posixfs"
+)
+
+// MaxIgnoreFileSize is the largest ignore file which will be read.
+const MaxIgnoreFileSize = 1 << 20
+
+// ImportOption configures Import and ImportIncremental
+type ImportOption func(*importConfig)
+
+type importConfig struct {
+	ignoreFuncs []func(p string, isDir bool) bool
+	ignoreSet   IgnoreSet
+	ignoreFile  string
+	record      *IgnoreSet
+}
+
+func newImportConfig(opts []ImportOption) importConfig {
+	var cfg importConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// WithIgnoreFunc causes paths for which fn returns true to be left out of the import.
+// p is relative to the path being imported.
+// If fn returns true for a directory, then it is never read.
+func WithIgnoreFunc(fn func(p string, isDir bool) bool) ImportOption {
+	return func(c *importConfig) {
+		c.ignoreFuncs = append(c.ignoreFuncs, fn)
+	}
+}
+
+// WithIgnorePatterns causes paths matching the gitignore style patterns to be left out of the import.
+// The patterns apply as if they were in an ignore file at the root of the import.
+func WithIgnorePatterns(patterns ...string) ImportOption {
+	return func(c *importConfig) {
+		c.ignoreSet = c.ignoreSet.add("", patterns)
+	}
+}
+
+// WithIgnoreFiles causes files with name, such as ".gitignore", to be read from each directory in the import.
+// The patterns in each file apply to the directory containing it, as they would for git.
+// The ignore files themselves are imported, unless they are ignored.
+func WithIgnoreFiles(name string) ImportOption {
+	return func(c *importConfig) {
+		c.ignoreFile = name
+	}
+}
+
+// WithIgnoreSet uses the patterns in set, which is usually recorded from a previous import with RecordIgnoreSet.
+// Passing a recorded set, without WithIgnoreFiles, ignores exactly what was ignored before, even if the ignore files have changed.
+func WithIgnoreSet(set IgnoreSet) ImportOption {
+	return func(c *importConfig) {
+		for dir, patterns := range set {
+			c.ignoreSet = c.ignoreSet.add(dir, patterns)
+		}
+	}
+}
+
+// RecordIgnoreSet causes all the patterns used by the import, from any source, to be written to dst.
+// Ignore funcs cannot be recorded.
+func RecordIgnoreSet(dst *IgnoreSet) ImportOption {
+	return func(c *importConfig) {
+		c.record = dst
+	}
+}
+
+// IgnoreSet maps directories, relative to the root of an import, to the gitignore style patterns which apply beneath them.
+// The root is "".
+// IgnoreSet can be persisted by marshaling it as JSON.
+type IgnoreSet map[string][]string
+
+func (s IgnoreSet) add(dir string, patterns []string) IgnoreSet {
+	if len(patterns) == 0 {
+		return s
+	}
+	if s == nil {
+		s = IgnoreSet{}
+	}
+	s[dir] = append(s[dir], patterns...)
+	return s
+}
+
+// ParseIgnoreFile returns the patterns in a gitignore style file, without blank lines or comments.
+func ParseIgnoreFile(data []byte) []string {
+	var ret []string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := strings.TrimSuffix(sc.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// trailing spaces are ignored, unless they are escaped.
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = line[:len(line)-1]
+		}
+		if line == "" {
+			continue
+		}
+		ret = append(ret, line)
+	}
+	return ret
+}
+
+// ignorer decides which paths to leave out of an import.
+// A nil ignorer ignores nothing.
+type ignorer struct {
+	cfg   *importConfig
+	rules []ignoreRule
+	// recorded is shared by all the ignorers in an import.
+	recorded *ignoreRecord
+}
+
+type ignoreRecord struct {
+	mu  sync.Mutex
+	set IgnoreSet
+}
+
+func newIgnorer(cfg importConfig) *ignorer {
+	if len(cfg.ignoreFuncs) == 0 && len(cfg.ignoreSet) == 0 && cfg.ignoreFile == "" {
+		return nil
+	}
+	return &ignorer{cfg: &cfg, recorded: &ignoreRecord{}}
+}
+
+// enter returns the ignorer for the directory dir, at target in fsx, which contains ents.
+func (ig *ignorer) enter(fsx posixfs.FS, target, dir string, ents []posixfs.DirEnt) (*ignorer, error) {
+	if ig == nil {
+		return nil, nil
+	}
+	patterns := slices.Clone(ig.cfg.ignoreSet[dir])
+	if name := ig.cfg.ignoreFile; name != "" && slices.ContainsFunc(ents, func(ent posixfs.DirEnt) bool {
+		return ent.Name == name && ent.Mode.IsRegular()
+	}) {
+		data, err := readIgnoreFile(fsx, path.Join(target, name))
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, ParseIgnoreFile(data)...)
+	}
+	if len(patterns) == 0 {
+		return ig, nil
+	}
+	ig.recorded.mu.Lock()
+	ig.recorded.set = ig.recorded.set.add(dir, patterns)
+	ig.recorded.mu.Unlock()
+
+	ig2 := *ig
+	ig2.rules = slices.Clip(ig.rules)
+	for _, pattern := range patterns {
+		ig2.rules = append(ig2.rules, parseIgnoreRule(dir, pattern))
+	}
+	return &ig2, nil
+}
+
+// ignored returns true if p should be left out of the import.
+func (ig *ignorer) ignored(p string, isDir bool) bool {
+	if ig == nil {
+		return false
+	}
+	for _, fn := range ig.cfg.ignoreFuncs {
+		if fn(p, isDir) {
+			return true
+		}
+	}
+	// the last matching rule wins
+	for i := len(ig.rules) - 1; i >= 0; i-- {
+		if ig.rules[i].matches(p, isDir) {
+			return !ig.rules[i].negate
+		}
+	}
+	return false
+}
+
+// finish writes the recorded patterns to the destination, if there is one.
+func (ig *ignorer) finish() {
+	if ig == nil || ig.cfg.record == nil {
+		return
+	}
+	ig.recorded.mu.Lock()
+	defer ig.recorded.mu.Unlock()
+	*ig.cfg.record = maps.Clone(ig.recorded.set)
+}
+
+// ignoreRule is a single gitignore style pattern.
+type ignoreRule struct {
+	// dir is the directory containing the rule, relative to the root of the import.
+	dir string
+	// elems is the pattern split on "/".  A pattern which only matches the base name is prefixed with "**".
+	elems   []string
+	negate  bool
+	dirOnly bool
+}
+
+func parseIgnoreRule(dir, pattern string) ignoreRule {
+	r := ignoreRule{dir: dir}
+	if strings.HasPrefix(pattern, "!") {
+		r.negate = true
+		pattern = pattern[1:]
+	} else if strings.HasPrefix(pattern, "\\!") || strings.HasPrefix(pattern, "\\#") {
+		pattern = pattern[1:]
+	}
+	if strings.HasSuffix(pattern, "/") {
+		r.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	// a pattern with a separator is relative to dir, otherwise it matches at any depth.
+	if !strings.Contains(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	pattern = strings.TrimPrefix(pattern, "/")
+	r.elems = strings.Split(pattern, "/")
+	return r
+}
+
+func (r ignoreRule) matches(p string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.dir != "" {
+		rel, ok := strings.CutPrefix(p, r.dir+"/")
+		if !ok {
+			return false
+		}
+		p = rel
+	}
+	return matchElems(r.elems, strings.Split(p, "/"))
+}
+
+// matchElems matches a path against a pattern, one element at a time.
+// An element of "**" matches zero or more elements of the path.
+func matchElems(pattern, elems []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(elems); i++ {
+				if matchElems(pattern[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], elems[0]); err != nil || !ok {
+			return false
+		}
+		pattern, elems = pattern[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
+
+func readIgnoreFile(fsx posixfs.FS, p string) ([]byte, error) {
+	f, err := fsx.OpenFile(p, posixfs.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(io.LimitReader(f, MaxIgnoreFileSize))
+}
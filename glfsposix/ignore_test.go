@@ -0,0 +1,118 @@
+package glfsposix
+
+import (
+	"context"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+
+	"blobcache.io/blobcache/src/blobcache"
+	"blobcache.io/blobcache/src/schema"
+	"blobcache.io/glfs"
+	"github.com/stretchr/testify/require"
+	"go.brendoncarroll.net/state/posixfs"
+	"golang.org/x/sync/semaphore"
+)
+
+func TestIgnoreRule(t *testing.T) {
+	tcs := []struct {
+		Dir, Pattern string
+		Path         string
+		IsDir        bool
+		Match        bool
+	}{
+		{Pattern: "*.swp", Path: "a.swp", Match: true},
+		{Pattern: "*.swp", Path: "x/y/a.swp", Match: true},
+		{Pattern: "*.swp", Path: "a.swpx", Match: false},
+		{Pattern: "/build", Path: "build", IsDir: true, Match: true},
+		{Pattern: "/build", Path: "x/build", IsDir: true, Match: false},
+		{Pattern: "build/", Path: "x/build", IsDir: true, Match: true},
+		{Pattern: "build/", Path: "build", IsDir: false, Match: false},
+		{Pattern: "docs/*.md", Path: "docs/a.md", Match: true},
+		{Pattern: "docs/*.md", Path: "x/docs/a.md", Match: false},
+		{Pattern: "docs/*.md", Path: "docs/x/a.md", Match: false},
+		{Pattern: "**/logs", Path: "a/b/logs", IsDir: true, Match: true},
+		{Pattern: "a/**/b", Path: "a/b", Match: true},
+		{Pattern: "a/**/b", Path: "a/x/y/b", Match: true},
+		{Pattern: "\\#x", Path: "#x", Match: true},
+		{Dir: "sub", Pattern: "/out", Path: "sub/out", IsDir: true, Match: true},
+		{Dir: "sub", Pattern: "/out", Path: "out", IsDir: true, Match: false},
+		{Dir: "sub", Pattern: "*.o", Path: "sub/x/a.o", Match: true},
+		{Dir: "sub", Pattern: "*.o", Path: "other/a.o", Match: false},
+	}
+	for _, tc := range tcs {
+		r := parseIgnoreRule(tc.Dir, tc.Pattern)
+		require.Equal(t, tc.Match, r.matches(tc.Path, tc.IsDir), "dir=%q pattern=%q path=%q", tc.Dir, tc.Pattern, tc.Path)
+	}
+}
+
+func TestParseIgnoreFile(t *testing.T) {
+	data := "# comment\n\n*.swp  \nbuild/\r\n!keep.swp\nspace\\ \n"
+	require.Equal(t, []string{"*.swp", "build/", "!keep.swp", "space\\ "}, ParseIgnoreFile([]byte(data)))
+}
+
+func TestImportIgnore(t *testing.T) {
+	ctx := context.Background()
+	ag := glfs.NewMachine()
+	s := schema.NewMem(blobcache.HashAlgo_BLAKE3_256.HashFunc(), glfs.DefaultBlockSize)
+	sem := semaphore.NewWeighted(4)
+	fsx := &openCounter{FS: posixfs.NewTestFS(t)}
+	put := func(p, data string) {
+		require.NoError(t, posixfs.MkdirAll(fsx, path.Dir(p), 0o755))
+		require.NoError(t, posixfs.PutFile(ctx, fsx, p, 0o644, strings.NewReader(data)))
+	}
+	put(".gitignore", "*.swp\n!keep.swp\nbuild/\n")
+	put("main.go", "package main")
+	put("main.go.swp", "swap")
+	put("keep.swp", "keep")
+	put("build/out", "binary")
+	put("node_modules/x/index.js", "")
+	put("sub/.gitignore", "/local.txt\n")
+	put("sub/local.txt", "local")
+	put("sub/x/local.txt", "not local")
+	put(".git/HEAD", "ref: refs/heads/main")
+
+	var recorded IgnoreSet
+	root, err := Import(ctx, ag, sem, s, fsx, "",
+		WithIgnoreFiles(".gitignore"),
+		WithIgnorePatterns("node_modules/"),
+		WithIgnoreFunc(func(p string, isDir bool) bool {
+			return path.Base(p) == ".git"
+		}),
+		RecordIgnoreSet(&recorded),
+	)
+	require.NoError(t, err)
+	var paths []string
+	require.NoError(t, ag.WalkTree(ctx, s, *root, func(prefix string, ent glfs.TreeEntry) error {
+		if ent.Ref.Type == glfs.TypeBlob {
+			paths = append(paths, path.Join(prefix, ent.Name))
+		}
+		return nil
+	}))
+	slices.Sort(paths)
+	require.Equal(t, []string{".gitignore", "keep.swp", "main.go", "sub/.gitignore", "sub/x/local.txt"}, paths)
+	// ignored directories are never read.
+	for _, dir := range fsx.dirs {
+		require.NotContains(t, []string{"build", "node_modules", ".git"}, dir)
+	}
+	require.Equal(t, IgnoreSet{
+		"":    {"node_modules/", "*.swp", "!keep.swp", "build/"},
+		"sub": {"/local.txt"},
+	}, recorded)
+
+	// reimporting with the recorded set ignores the same things, even if the ignore files change.
+	put(".gitignore", "")
+	root2, err := Import(ctx, ag, sem, s, fsx, "",
+		WithIgnoreSet(recorded),
+		WithIgnoreFunc(func(p string, isDir bool) bool {
+			return path.Base(p) == ".git"
+		}),
+	)
+	require.NoError(t, err)
+	ref, err := ag.GetAtPath(ctx, s, *root2, "main.go.swp")
+	require.Error(t, err)
+	require.Nil(t, ref)
+	_, err = ag.GetAtPath(ctx, s, *root2, "keep.swp")
+	require.NoError(t, err)
+}
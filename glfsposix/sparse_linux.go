@@ -0,0 +1,33 @@
+//go:build linux
+
+package glfsposix
+
+import (
+	"errors"
+	"syscall"
+
+	"go.brendoncarroll.net/state/posixfs"
+)
+
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// nextData returns the extent [start, end) of the next data in f, at or after offset.
+// If there is no more data, start will be size.
+// If the filesystem does not support SEEK_DATA, then everything after offset is considered data.
+func nextData(f posixfs.File, offset, size int64) (start, end int64, _ error) {
+	start, err := f.Seek(offset, seekData)
+	if err != nil {
+		if errors.Is(err, syscall.ENXIO) {
+			return size, size, nil
+		}
+		return offset, size, nil
+	}
+	end, err = f.Seek(start, seekHole)
+	if err != nil {
+		return start, size, nil
+	}
+	return start, min(end, size), nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package glfsposix
+
+import "go.brendoncarroll.net/state/posixfs"
+
+// nextData returns the extent [start, end) of the next data in f, at or after offset.
+// Holes can only be detected on Linux, so everything after offset is considered data.
+func nextData(f posixfs.File, offset, size int64) (start, end int64, _ error) {
+	return offset, size, nil
+}
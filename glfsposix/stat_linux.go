@@ -0,0 +1,18 @@
+//go:build linux
+
+package glfsposix
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// statSys returns the inode number and change time of the file, if they are available.
+func statSys(finfo os.FileInfo) (ino uint64, ctime time.Time) {
+	st, ok := finfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, time.Time{}
+	}
+	return st.Ino, time.Unix(st.Ctim.Sec, st.Ctim.Nsec)
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package glfsposix
+
+import (
+	"os"
+	"time"
+)
+
+// statSys returns the inode number and change time of the file.
+// They are only available on Linux, elsewhere the size and modification time are used to detect changes.
+func statSys(finfo os.FileInfo) (ino uint64, ctime time.Time) {
+	return 0, time.Time{}
+}
@@ -0,0 +1,88 @@
+package glfsposix
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"blobcache.io/glfs"
+)
+
+// StatCache records the stat information and Ref of every file and directory from an import.
+// It is used by ImportIncremental to skip reading files which have not changed.
+//
+// StatCache can be persisted by marshaling it as JSON.
+// It contains Refs, including their keys, so it should be stored as carefully as the roots themselves.
+type StatCache struct {
+	// Root is the Ref produced by the import which filled in the cache.
+	Root glfs.Ref `json:"root"`
+	// Time is when the import began, truncated to the second.
+	// Files modified at or after Time are not trusted, since they could have changed again without their ModTime changing.
+	Time time.Time `json:"time"`
+	// Entries are keyed by path, relative to the path which was imported.
+	Entries map[string]StatEntry `json:"entries"`
+}
+
+// StatEntry is the stat information for a single path.
+type StatEntry struct {
+	Mode    os.FileMode `json:"mode"`
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"mtime"`
+	CTime   time.Time   `json:"ctime,omitzero"`
+	Inode   uint64      `json:"inode,omitempty"`
+
+	// Ref is the object which was imported from the path.
+	Ref glfs.Ref `json:"ref"`
+	// Children is the number of entries in a directory.
+	Children int `json:"children,omitempty"`
+}
+
+func newStatEntry(finfo os.FileInfo) StatEntry {
+	ino, ctime := statSys(finfo)
+	return StatEntry{
+		Mode:    finfo.Mode(),
+		Size:    finfo.Size(),
+		ModTime: finfo.ModTime(),
+		CTime:   ctime,
+		Inode:   ino,
+	}
+}
+
+// sameFile returns true if the file described by x has not changed since e was recorded.
+func (e StatEntry) sameFile(x StatEntry) bool {
+	return e.Mode == x.Mode &&
+		e.Size == x.Size &&
+		e.ModTime.Equal(x.ModTime) &&
+		e.CTime.Equal(x.CTime) &&
+		e.Inode == x.Inode
+}
+
+// statCacheState holds the cache being read from, and the one being written to, during an import.
+// A nil statCacheState does nothing.
+type statCacheState struct {
+	prev *StatCache
+
+	mu   sync.Mutex
+	next map[string]StatEntry
+}
+
+// lookup returns the entry for p if it can be trusted.
+func (sc *statCacheState) lookup(p string) (StatEntry, bool) {
+	if sc == nil || sc.prev == nil {
+		return StatEntry{}, false
+	}
+	e, ok := sc.prev.Entries[p]
+	if !ok || !e.ModTime.Before(sc.prev.Time) {
+		return StatEntry{}, false
+	}
+	return e, true
+}
+
+func (sc *statCacheState) put(p string, e StatEntry) {
+	if sc == nil {
+		return
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.next[p] = e
+}
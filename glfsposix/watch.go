@@ -0,0 +1,237 @@
+package glfsposix
+
+import (
+	"context"
+	"errors"
+	"path"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.brendoncarroll.net/state/posixfs"
+	"golang.org/x/sync/semaphore"
+
+	"blobcache.io/blobcache/src/schema"
+	"blobcache.io/glfs"
+	"blobcache.io/glfs/glfsrefs"
+)
+
+// DefaultDebounce is how long Watch waits for changes to stop before importing them.
+const DefaultDebounce = 100 * time.Millisecond
+
+// WatchOption configures Watch
+type WatchOption func(*watchConfig)
+
+type watchConfig struct {
+	debounce   time.Duration
+	maxDelay   time.Duration
+	importOpts []ImportOption
+}
+
+func newWatchConfig(opts []WatchOption) watchConfig {
+	cfg := watchConfig{
+		debounce: DefaultDebounce,
+		maxDelay: 10 * DefaultDebounce,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// WithDebounce sets how long Watch waits after a change for more changes, before importing them.
+// Changes are always imported within maxDelay of the first one, even if more changes keep arriving.
+func WithDebounce(debounce, maxDelay time.Duration) WatchOption {
+	return func(c *watchConfig) {
+		c.debounce = debounce
+		c.maxDelay = max(debounce, maxDelay)
+	}
+}
+
+// WithImportOptions passes opts to every import done by Watch.
+func WithImportOptions(opts ...ImportOption) WatchOption {
+	return func(c *watchConfig) {
+		c.importOpts = append(c.importOpts, opts...)
+	}
+}
+
+// PublishFunc is called by Watch with each new root.
+type PublishFunc = func(ctx context.Context, root glfs.Ref) error
+
+// PublishToRefStore returns a PublishFunc which sets name in rs to each new root.
+func PublishToRefStore(rs glfsrefs.RefStore, name string) PublishFunc {
+	return func(ctx context.Context, root glfs.Ref) error {
+		_, err := glfsrefs.Tx(ctx, rs, name, func(*glfs.Ref) (*glfs.Ref, error) {
+			return &root, nil
+		})
+		return err
+	}
+}
+
+// watcher keeps a root up to date with a directory, by re-importing the paths which have changed.
+type watcher struct {
+	ag  *glfs.Machine
+	sem *semaphore.Weighted
+	s   schema.RW
+	fs  posixfs.FS
+	cfg importConfig
+
+	root glfs.Ref
+}
+
+// importAll imports the whole directory.
+func (w *watcher) importAll(ctx context.Context) (*glfs.Ref, error) {
+	ig := newIgnorer(w.cfg)
+	ref, _, err := glfsImport(ctx, glfsImportParams{
+		ag:     w.ag,
+		sem:    w.sem,
+		s:      w.s,
+		fs:     w.fs,
+		target: "",
+		ignore: ig,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ig.finish()
+	return ref, nil
+}
+
+// update re-imports the paths in dirty, and applies them to the current root.
+// Paths are relative to the directory, and "" is the directory itself.
+func (w *watcher) update(ctx context.Context, dirty []string) (*glfs.Ref, error) {
+	dirty = collapsePaths(dirty)
+	if slices.Contains(dirty, "") {
+		return w.importAll(ctx)
+	}
+	var cs glfs.ChangeSet
+	for _, p := range dirty {
+		old, err := w.ag.GetAtPath(ctx, w.s, w.root, p)
+		if err != nil && !glfs.IsErrNoEnt(err) {
+			return nil, err
+		}
+		ent, err := w.importPath(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case ent == nil && old == nil:
+		case ent == nil:
+			cs = append(cs, glfs.Edit{Path: p, Op: glfs.EditDelete, Old: old})
+		default:
+			cs = append(cs, glfs.Edit{Path: p, Op: glfs.EditPut, Mode: ent.FileMode, Ref: &ent.Ref, Old: old})
+		}
+	}
+	if len(cs) == 0 {
+		return &w.root, nil
+	}
+	ref, err := w.ag.Apply(ctx, w.s, w.s, w.root, cs)
+	if err != nil {
+		// the changes could not be applied to the tree, for example a path changed type beneath an edit.
+		return w.importAll(ctx)
+	}
+	return ref, nil
+}
+
+// importPath imports the path p, as it would be imported by importAll.
+// It returns nil if p does not exist, or is ignored.
+func (w *watcher) importPath(ctx context.Context, p string) (*glfs.TreeEntry, error) {
+	dir, name := parentDir(p), path.Base(p)
+	ig, ents, ok, err := w.ignorerAt(dir)
+	if err != nil || !ok {
+		return nil, err
+	}
+	i := slices.IndexFunc(ents, func(ent posixfs.DirEnt) bool { return ent.Name == name })
+	if i < 0 || ig.ignored(p, ents[i].Mode.IsDir()) {
+		return nil, nil
+	}
+	ref, _, err := glfsImport(ctx, glfsImportParams{
+		ag:     w.ag,
+		sem:    w.sem,
+		s:      w.s,
+		fs:     w.fs,
+		target: p,
+		rel:    p,
+		ignore: ig,
+	})
+	if err != nil {
+		if posixfs.IsErrNotExist(err) {
+			// p was removed while it was being imported, there will be another event for it.
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &glfs.TreeEntry{Name: name, FileMode: ents[i].Mode, Ref: *ref}, nil
+}
+
+// ignorerAt returns the ignorer for the entries of the directory dir, and the entries.
+// ok is false if dir does not exist, or is ignored.
+func (w *watcher) ignorerAt(dir string) (_ *ignorer, _ []posixfs.DirEnt, ok bool, _ error) {
+	var elems []string
+	if dir != "" {
+		elems = strings.Split(dir, "/")
+	}
+	ig := newIgnorer(w.cfg)
+	var cur string
+	for i := 0; ; i++ {
+		ents, err := posixfs.ReadDir(w.fs, cur)
+		if err != nil {
+			if posixfs.IsErrNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+				return nil, nil, false, nil
+			}
+			return nil, nil, false, err
+		}
+		if ig, err = ig.enter(w.fs, cur, cur, ents); err != nil {
+			return nil, nil, false, err
+		}
+		if i == len(elems) {
+			return ig, ents, true, nil
+		}
+		j := slices.IndexFunc(ents, func(ent posixfs.DirEnt) bool { return ent.Name == elems[i] })
+		cur = path.Join(cur, elems[i])
+		if j < 0 || !ents[j].Mode.IsDir() || ig.ignored(cur, true) {
+			return nil, nil, false, nil
+		}
+	}
+}
+
+// parentDir returns the directory containing p, where "" is the root.
+func parentDir(p string) string {
+	if dir := path.Dir(p); dir != "." {
+		return dir
+	}
+	return ""
+}
+
+// collapsePaths sorts and deduplicates ps, and removes paths which are beneath another path in ps.
+func collapsePaths(ps []string) []string {
+	set := make(map[string]struct{}, len(ps))
+	for _, p := range ps {
+		set[p] = struct{}{}
+	}
+	var ret []string
+	for p := range set {
+		if !hasAncestor(set, p) {
+			ret = append(ret, p)
+		}
+	}
+	slices.Sort(ret)
+	return ret
+}
+
+// hasAncestor returns true if a directory containing p is in set.
+func hasAncestor(set map[string]struct{}, p string) bool {
+	if p == "" {
+		return false
+	}
+	if _, ok := set[""]; ok {
+		return true
+	}
+	for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+		if _, ok := set[dir]; ok {
+			return true
+		}
+	}
+	return false
+}
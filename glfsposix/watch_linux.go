@@ -0,0 +1,254 @@
+//go:build linux
+
+package glfsposix
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.brendoncarroll.net/state/posixfs"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+
+	"blobcache.io/blobcache/src/schema"
+	"blobcache.io/glfs"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ATTRIB | syscall.IN_DELETE_SELF |
+	syscall.IN_ONLYDIR | syscall.IN_DONT_FOLLOW
+
+// Watch imports the directory dir, and then keeps the import up to date as the directory changes.
+// fn is called with the first root, and then with each new root.
+// Roots are only published when they change, and the same store s is used for every import.
+//
+// Changes are observed with inotify, and are debounced, see WithDebounce.
+// Only the paths which have changed are imported again, and their ancestors are rebuilt with Machine.Apply.
+// If inotify drops events, then the whole directory is imported again.
+//
+// Watch runs until ctx is done, or an error occurs, including from fn.
+func Watch(ctx context.Context, ag *glfs.Machine, sem *semaphore.Weighted, s schema.RW, dir string, fn PublishFunc, opts ...WatchOption) error {
+	cfg := newWatchConfig(opts)
+	w := &watcher{
+		ag:  ag,
+		sem: sem,
+		s:   s,
+		fs:  posixfs.NewDirFS(dir),
+		cfg: newImportConfig(cfg.importOpts),
+	}
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return os.NewSyscallError("inotify_init1", err)
+	}
+	// the file is non-blocking, so reads wait in the runtime poller, and are interrupted by Close.
+	f := os.NewFile(uintptr(fd), "inotify")
+	defer f.Close()
+	iw := &inotify{w: w, f: f, fd: fd, dir: dir, wds: map[int32]string{}}
+	// the watches are added before the import, so that no changes are missed.
+	if err := iw.addDir(""); err != nil {
+		return err
+	}
+	root, err := w.importAll(ctx)
+	if err != nil {
+		return err
+	}
+	w.root = *root
+	if err := fn(ctx, *root); err != nil {
+		return err
+	}
+
+	eg, ctx := errgroup.WithContext(ctx)
+	events := make(chan []string)
+	eg.Go(func() error {
+		<-ctx.Done()
+		return f.Close()
+	})
+	eg.Go(func() error {
+		return iw.run(ctx, events)
+	})
+	eg.Go(func() error {
+		return w.run(ctx, cfg, events, fn)
+	})
+	return eg.Wait()
+}
+
+// run collects the dirty paths from events, and publishes a new root once they stop changing.
+func (w *watcher) run(ctx context.Context, cfg watchConfig, events <-chan []string, fn PublishFunc) error {
+	timer := time.NewTimer(cfg.debounce)
+	timer.Stop()
+	var fire <-chan time.Time
+	var dirty []string
+	var first time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ps := <-events:
+			if len(dirty) == 0 {
+				first = time.Now()
+			}
+			dirty = append(dirty, ps...)
+			timer.Reset(min(cfg.debounce, time.Until(first.Add(cfg.maxDelay))))
+			fire = timer.C
+		case <-fire:
+			fire = nil
+			root, err := w.update(ctx, dirty)
+			if err != nil {
+				return err
+			}
+			dirty = nil
+			if root.Equals(w.root) {
+				continue
+			}
+			w.root = *root
+			if err := fn(ctx, *root); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// inotify turns inotify events into dirty paths, and adds watches for new directories.
+type inotify struct {
+	w   *watcher
+	f   *os.File
+	fd  int
+	dir string
+	// wds maps watch descriptors to directories, relative to dir.
+	wds map[int32]string
+}
+
+func (iw *inotify) run(ctx context.Context, out chan<- []string) error {
+	buf := make([]byte, 1<<16)
+	for {
+		n, err := iw.f.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		dirty, err := iw.handle(buf[:n])
+		if err != nil {
+			return err
+		}
+		if len(dirty) == 0 {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case out <- dirty:
+		}
+	}
+}
+
+// handle returns the paths changed by the events in buf.
+func (iw *inotify) handle(buf []byte) ([]string, error) {
+	var dirty []string
+	for len(buf) >= syscall.SizeofInotifyEvent {
+		wd := int32(binary.NativeEndian.Uint32(buf[0:]))
+		mask := binary.NativeEndian.Uint32(buf[4:])
+		nameLen := binary.NativeEndian.Uint32(buf[12:])
+		name := strings.TrimRight(string(buf[syscall.SizeofInotifyEvent:syscall.SizeofInotifyEvent+nameLen]), "\x00")
+		buf = buf[syscall.SizeofInotifyEvent+nameLen:]
+
+		if mask&syscall.IN_Q_OVERFLOW != 0 {
+			// events were dropped, so everything has to be imported again.
+			if err := iw.addDir(""); err != nil {
+				return nil, err
+			}
+			dirty = append(dirty, "")
+			continue
+		}
+		if mask&syscall.IN_IGNORED != 0 {
+			delete(iw.wds, wd)
+			continue
+		}
+		dir, ok := iw.wds[wd]
+		if !ok {
+			continue
+		}
+		p := path.Join(dir, name)
+		isDir := mask&syscall.IN_ISDIR != 0
+		switch {
+		case name == "":
+			// the event is for the watched directory itself.
+		case isDir && mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+			if err := iw.addDir(p); err != nil {
+				return nil, err
+			}
+		case isDir && mask&syscall.IN_MOVED_FROM != 0:
+			// the watches stay with the directory, they will be given new paths if it is moved to somewhere being watched.
+			for wd2, dir2 := range iw.wds {
+				if dir2 == p || strings.HasPrefix(dir2, p+"/") {
+					delete(iw.wds, wd2)
+				}
+			}
+		case name == iw.w.cfg.ignoreFile:
+			// an ignore file affects everything in its directory.
+			if err := iw.addDir(dir); err != nil {
+				return nil, err
+			}
+			p = dir
+		}
+		dirty = append(dirty, p)
+	}
+	return dirty, nil
+}
+
+// addDir adds watches for the directory p, and everything beneath it which is not ignored.
+func (iw *inotify) addDir(p string) error {
+	if p == "" {
+		return iw.addTree("", newIgnorer(iw.w.cfg))
+	}
+	ig, _, ok, err := iw.w.ignorerAt(parentDir(p))
+	if err != nil || !ok {
+		return err
+	}
+	if ig.ignored(p, true) {
+		return nil
+	}
+	return iw.addTree(p, ig)
+}
+
+// addTree adds watches for the directory p, and everything beneath it which is not ignored.
+// ig is the ignorer for the directory containing p.
+func (iw *inotify) addTree(p string, ig *ignorer) error {
+	wd, err := syscall.InotifyAddWatch(iw.fd, filepath.Join(iw.dir, filepath.FromSlash(p)), inotifyMask)
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) || errors.Is(err, syscall.ENOTDIR) {
+			// p was removed or replaced, there will be another event for it.
+			return nil
+		}
+		return os.NewSyscallError("inotify_add_watch", err)
+	}
+	iw.wds[int32(wd)] = p
+	ents, err := posixfs.ReadDir(iw.w.fs, p)
+	if err != nil {
+		if posixfs.IsErrNotExist(err) || errors.Is(err, syscall.ENOTDIR) {
+			return nil
+		}
+		return err
+	}
+	if ig, err = ig.enter(iw.w.fs, p, p, ents); err != nil {
+		return err
+	}
+	for _, ent := range ents {
+		p2 := path.Join(p, ent.Name)
+		if !ent.Mode.IsDir() || ig.ignored(p2, true) {
+			continue
+		}
+		if err := iw.addTree(p2, ig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
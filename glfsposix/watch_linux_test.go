@@ -0,0 +1,87 @@
+//go:build linux
+
+package glfsposix
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"blobcache.io/blobcache/src/blobcache"
+	"blobcache.io/blobcache/src/schema"
+	"blobcache.io/glfs"
+	"github.com/stretchr/testify/require"
+	"go.brendoncarroll.net/state/posixfs"
+	"golang.org/x/sync/semaphore"
+
+	"blobcache.io/glfs/glfsrefs"
+)
+
+func TestWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ag := glfs.NewMachine()
+	s := schema.NewMem(blobcache.HashAlgo_BLAKE3_256.HashFunc(), glfs.DefaultBlockSize)
+	sem := semaphore.NewWeighted(4)
+	dir := t.TempDir()
+	put := func(p, data string) {
+		p = filepath.Join(dir, p)
+		require.NoError(t, os.MkdirAll(filepath.Dir(p), 0o755))
+		require.NoError(t, os.WriteFile(p, []byte(data), 0o644))
+	}
+	put("a.txt", "a")
+	put("sub/b.txt", "b")
+
+	rs := glfsrefs.NewMem()
+	publish := PublishToRefStore(rs, "snapshot")
+	roots := make(chan glfs.Ref, 100)
+	done := make(chan error, 1)
+	go func() {
+		done <- Watch(ctx, ag, sem, s, dir, func(ctx context.Context, root glfs.Ref) error {
+			roots <- root
+			return publish(ctx, root)
+		}, WithDebounce(10*time.Millisecond, 100*time.Millisecond))
+	}()
+	// waitFor waits until the published root matches the directory.
+	waitFor := func() {
+		t.Helper()
+		expected, err := Import(ctx, ag, sem, s, posixfs.NewDirFS(dir), "")
+		require.NoError(t, err)
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case root := <-roots:
+				if root.Equals(*expected) {
+					stored, err := rs.Get(ctx, "snapshot")
+					require.NoError(t, err)
+					require.Equal(t, *expected, *stored)
+					return
+				}
+			case err := <-done:
+				t.Fatal(err)
+			case <-timeout:
+				t.Fatal("timed out waiting for root")
+			}
+		}
+	}
+	waitFor()
+
+	put("a.txt", "a2")
+	waitFor()
+	put("sub/new/deep/c.txt", "c")
+	waitFor()
+	// the new directories are being watched.
+	put("sub/new/deep/d.txt", "d")
+	waitFor()
+	require.NoError(t, os.Rename(filepath.Join(dir, "sub/new"), filepath.Join(dir, "moved")))
+	waitFor()
+	put("moved/deep/e.txt", "e")
+	waitFor()
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "sub")))
+	waitFor()
+
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+}
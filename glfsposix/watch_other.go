@@ -0,0 +1,19 @@
+//go:build !linux
+
+package glfsposix
+
+import (
+	"context"
+	"errors"
+
+	"golang.org/x/sync/semaphore"
+
+	"blobcache.io/blobcache/src/schema"
+	"blobcache.io/glfs"
+)
+
+// Watch imports the directory dir, and then keeps the import up to date as the directory changes.
+// Changes can only be observed on Linux, so it always returns an error on other platforms.
+func Watch(ctx context.Context, ag *glfs.Machine, sem *semaphore.Weighted, s schema.RW, dir string, fn PublishFunc, opts ...WatchOption) error {
+	return errors.New("glfsposix: Watch is only supported on linux")
+}
@@ -0,0 +1,65 @@
+package glfsposix
+
+import (
+	"context"
+	"path"
+	"strings"
+	"testing"
+
+	"blobcache.io/blobcache/src/blobcache"
+	"blobcache.io/blobcache/src/schema"
+	"blobcache.io/glfs"
+	"github.com/stretchr/testify/require"
+	"go.brendoncarroll.net/state/posixfs"
+	"golang.org/x/sync/semaphore"
+)
+
+func TestCollapsePaths(t *testing.T) {
+	require.Equal(t, []string{"a", "b/c"}, collapsePaths([]string{"b/c", "a/x", "a", "b/c/d", "a"}))
+	require.Equal(t, []string{""}, collapsePaths([]string{"a", "", "b"}))
+	require.Empty(t, collapsePaths(nil))
+}
+
+func TestWatcherUpdate(t *testing.T) {
+	ctx := context.Background()
+	ag := glfs.NewMachine()
+	s := schema.NewMem(blobcache.HashAlgo_BLAKE3_256.HashFunc(), glfs.DefaultBlockSize)
+	sem := semaphore.NewWeighted(4)
+	fsx := posixfs.NewTestFS(t)
+	put := func(p, data string) {
+		require.NoError(t, posixfs.MkdirAll(fsx, path.Dir(p), 0o755))
+		require.NoError(t, posixfs.PutFile(ctx, fsx, p, 0o644, strings.NewReader(data)))
+	}
+	put(".gitignore", "*.swp\n")
+	put("a.txt", "a")
+	put("sub/b.txt", "b")
+	put("sub/deep/c.txt", "c")
+	opts := []ImportOption{WithIgnoreFiles(".gitignore")}
+	w := &watcher{ag: ag, sem: sem, s: s, fs: fsx, cfg: newImportConfig(opts)}
+	root, err := w.importAll(ctx)
+	require.NoError(t, err)
+	w.root = *root
+
+	check := func(dirty ...string) {
+		t.Helper()
+		root, err := w.update(ctx, dirty)
+		require.NoError(t, err)
+		expected, err := Import(ctx, ag, sem, s, fsx, "", opts...)
+		require.NoError(t, err)
+		require.Equal(t, *expected, *root)
+		w.root = *root
+	}
+	put("sub/deep/c.txt", "c2")
+	check("sub/deep/c.txt")
+	put("sub/new/d.txt", "d")
+	check("sub/new", "sub/new/d.txt")
+	put("sub/x.swp", "ignored")
+	check("sub/x.swp")
+	require.NoError(t, fsx.Remove("a.txt"))
+	require.NoError(t, fsx.Rmdir("sub/deep"))
+	check("a.txt", "sub/deep", "sub/deep/c.txt")
+	put("sub/.gitignore", "b.txt\n")
+	check("sub")
+	put("e.txt", "e")
+	check("")
+}
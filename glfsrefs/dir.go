@@ -0,0 +1,179 @@
+package glfsrefs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"iter"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"blobcache.io/glfs"
+)
+
+// DirPollInterval is how often a DirStore checks for changes to a watched name.
+const DirPollInterval = 100 * time.Millisecond
+
+const refFileExt = ".ref"
+
+var _ RefStore = &DirStore{}
+
+// DirStore is a RefStore backed by a directory in the local filesystem.
+// Each name is stored as a file in the directory, containing a JSON encoded Ref.
+// Files are replaced atomically, and on unix systems CAS holds an exclusive lock on the directory,
+// so the same directory can be shared by multiple processes.
+type DirStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewDir returns a DirStore using the directory at p, which is created if it does not exist.
+func NewDir(p string) (*DirStore, error) {
+	if err := os.MkdirAll(p, 0o755); err != nil {
+		return nil, err
+	}
+	return &DirStore{dir: p}, nil
+}
+
+func (s *DirStore) Get(ctx context.Context, name string) (*glfs.Ref, error) {
+	if err := CheckName(name); err != nil {
+		return nil, err
+	}
+	return s.read(name)
+}
+
+func (s *DirStore) CAS(ctx context.Context, name string, prev, next *glfs.Ref) (bool, error) {
+	if err := CheckName(name); err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lf, err := os.OpenFile(filepath.Join(s.dir, "LOCK"), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return false, err
+	}
+	defer lf.Close()
+	if err := lockFile(lf); err != nil {
+		return false, err
+	}
+	defer unlockFile(lf)
+
+	actual, err := s.read(name)
+	if err != nil {
+		return false, err
+	}
+	if !refEqual(actual, prev) {
+		return false, nil
+	}
+	if next == nil {
+		if err := os.Remove(s.pathOf(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return false, err
+		}
+		return true, nil
+	}
+	if err := s.write(name, *next); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *DirStore) List(ctx context.Context) ([]string, error) {
+	dirEnts, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, dirEnt := range dirEnts {
+		escaped, ok := strings.CutSuffix(dirEnt.Name(), refFileExt)
+		if !ok || !dirEnt.Type().IsRegular() {
+			continue
+		}
+		name, err := url.PathUnescape(escaped)
+		if err != nil {
+			continue
+		}
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// Watch polls the file for name every DirPollInterval.
+func (s *DirStore) Watch(ctx context.Context, name string) iter.Seq2[*glfs.Ref, error] {
+	return func(yield func(*glfs.Ref, error) bool) {
+		if err := CheckName(name); err != nil {
+			yield(nil, err)
+			return
+		}
+		ticker := time.NewTicker(DirPollInterval)
+		defer ticker.Stop()
+		var last *glfs.Ref
+		for i := 0; ; i++ {
+			ref, err := s.read(name)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if i == 0 || !refEqual(last, ref) {
+				if !yield(ref, nil) {
+					return
+				}
+				last = ref
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}
+}
+
+func (s *DirStore) pathOf(name string) string {
+	return filepath.Join(s.dir, url.PathEscape(name)+refFileExt)
+}
+
+func (s *DirStore) read(name string) (*glfs.Ref, error) {
+	data, err := os.ReadFile(s.pathOf(name))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ref glfs.Ref
+	if err := json.Unmarshal(data, &ref); err != nil {
+		return nil, err
+	}
+	return &ref, nil
+}
+
+// write atomically replaces the file for name, by writing to a temporary file and renaming it.
+func (s *DirStore) write(name string, ref glfs.Ref) error {
+	data, err := json.Marshal(ref)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.pathOf(name))
+}
@@ -0,0 +1,105 @@
+// package glfsrefs provides mutable names for immutable glfs Refs.
+package glfsrefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"blobcache.io/glfs"
+)
+
+// MaxTxAttempts is the number of times Tx will retry before giving up.
+const MaxTxAttempts = 32
+
+// RefStore holds named pointers to Refs, which can be moved atomically.
+// A name which has not been set is equivalent to nil.
+type RefStore interface {
+	// Get returns the Ref at name, or nil if name is not set.
+	Get(ctx context.Context, name string) (*glfs.Ref, error)
+	// CAS sets name to next, if and only if name currently refers to prev.
+	// nil for prev means name is expected to be unset, nil for next unsets name.
+	// CAS returns false, and no error, if the swap failed because name did not refer to prev.
+	CAS(ctx context.Context, name string, prev, next *glfs.Ref) (bool, error)
+	// List returns every name which is set, in sorted order.
+	List(ctx context.Context) ([]string, error)
+	// Watch returns an iterator over the values of name.
+	// The current value is yielded first, followed by each new value as it is set.
+	// Intermediate values may be skipped if they are replaced before they can be yielded.
+	// Iteration ends when ctx is done.
+	Watch(ctx context.Context, name string) iter.Seq2[*glfs.Ref, error]
+}
+
+// ErrTxAttempts is returned by Tx when it could not commit after MaxTxAttempts.
+type ErrTxAttempts struct {
+	Name string
+}
+
+func (e ErrTxAttempts) Error() string {
+	return fmt.Sprintf("could not commit transaction on %q after %d attempts", e.Name, MaxTxAttempts)
+}
+
+func IsErrTxAttempts(err error) bool {
+	return errors.As(err, new(ErrTxAttempts))
+}
+
+// Tx loads the Ref at name, passes it to fn, and stores the Ref returned by fn using CAS.
+// If name is changed by another writer before the result can be stored, fn is called again with the new Ref.
+// fn should not have side effects other than posting data, since it may be called more than once.
+// Tx returns the Ref which was stored.
+func Tx(ctx context.Context, rs RefStore, name string, fn func(root *glfs.Ref) (*glfs.Ref, error)) (*glfs.Ref, error) {
+	backoff := time.Millisecond
+	for i := 0; i < MaxTxAttempts; i++ {
+		prev, err := rs.Get(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		next, err := fn(prev)
+		if err != nil {
+			return nil, err
+		}
+		if refEqual(prev, next) {
+			return next, nil
+		}
+		swapped, err := rs.CAS(ctx, name, prev, next)
+		if err != nil {
+			return nil, err
+		}
+		if swapped {
+			return next, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff/2 + rand.N(backoff)):
+		}
+		backoff = min(2*backoff, time.Second)
+	}
+	return nil, ErrTxAttempts{Name: name}
+}
+
+// CheckName returns an error if name cannot be used in a RefStore.
+// Names must not be empty, and must not contain empty path elements or "." or "..".
+func CheckName(name string) error {
+	if name == "" {
+		return errors.New("ref name cannot be empty")
+	}
+	for _, part := range strings.Split(name, "/") {
+		switch part {
+		case "", ".", "..":
+			return fmt.Errorf("invalid ref name %q", name)
+		}
+	}
+	return nil
+}
+
+func refEqual(a, b *glfs.Ref) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equals(*b)
+}
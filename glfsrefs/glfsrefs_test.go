@@ -0,0 +1,122 @@
+package glfsrefs
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"blobcache.io/glfs"
+	"blobcache.io/glfs/internal/glfstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMem(t *testing.T) {
+	testRefStore(t, func(t testing.TB) RefStore {
+		return NewMem()
+	})
+}
+
+func TestDir(t *testing.T) {
+	testRefStore(t, func(t testing.TB) RefStore {
+		rs, err := NewDir(t.TempDir())
+		require.NoError(t, err)
+		return rs
+	})
+}
+
+func testRefStore(t *testing.T, newRefStore func(testing.TB) RefStore) {
+	t.Run("CAS", func(t *testing.T) {
+		ctx := context.TODO()
+		rs := newRefStore(t)
+		s := glfstest.NewStore()
+		a := glfs.MustPostBlob(s, []byte("a"))
+		b := glfs.MustPostBlob(s, []byte("b"))
+
+		ref, err := rs.Get(ctx, "heads/main")
+		require.NoError(t, err)
+		require.Nil(t, ref)
+
+		swapped, err := rs.CAS(ctx, "heads/main", nil, &a)
+		require.NoError(t, err)
+		require.True(t, swapped)
+		swapped, err = rs.CAS(ctx, "heads/main", nil, &b)
+		require.NoError(t, err)
+		require.False(t, swapped)
+		swapped, err = rs.CAS(ctx, "heads/main", &a, &b)
+		require.NoError(t, err)
+		require.True(t, swapped)
+
+		ref, err = rs.Get(ctx, "heads/main")
+		require.NoError(t, err)
+		require.Equal(t, b, *ref)
+
+		_, err = rs.CAS(ctx, "other", nil, &a)
+		require.NoError(t, err)
+		names, err := rs.List(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []string{"heads/main", "other"}, names)
+
+		swapped, err = rs.CAS(ctx, "other", &a, nil)
+		require.NoError(t, err)
+		require.True(t, swapped)
+		names, err = rs.List(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []string{"heads/main"}, names)
+
+		_, err = rs.Get(ctx, "../escape")
+		require.Error(t, err)
+	})
+	t.Run("Tx", func(t *testing.T) {
+		ctx := context.TODO()
+		rs := newRefStore(t)
+		s := glfstest.NewStore()
+		const n = 10
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := Tx(ctx, rs, "main", func(root *glfs.Ref) (*glfs.Ref, error) {
+					layers := []glfs.Ref{}
+					if root != nil {
+						layers = append(layers, *root)
+					}
+					layers = append(layers, glfs.MustPostTreeMap(s, map[string]glfs.Ref{
+						strconv.Itoa(i): glfs.MustPostBlob(s, nil),
+					}))
+					return glfs.Merge(ctx, s, s, layers...)
+				})
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+		root, err := rs.Get(ctx, "main")
+		require.NoError(t, err)
+		tree, err := glfs.GetTreeSlice(ctx, s, *root, n+1)
+		require.NoError(t, err)
+		require.Len(t, tree, n)
+	})
+	t.Run("Watch", func(t *testing.T) {
+		ctx, cf := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cf()
+		rs := newRefStore(t)
+		s := glfstest.NewStore()
+		a := glfs.MustPostBlob(s, []byte("a"))
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			rs.CAS(ctx, "main", nil, &a)
+		}()
+		var refs []*glfs.Ref
+		for ref, err := range rs.Watch(ctx, "main") {
+			require.NoError(t, err)
+			refs = append(refs, ref)
+			if ref != nil {
+				break
+			}
+		}
+		require.Equal(t, []*glfs.Ref{nil, &a}, refs)
+	})
+}
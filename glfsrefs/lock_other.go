@@ -0,0 +1,15 @@
+//go:build !unix
+
+package glfsrefs
+
+import "os"
+
+// On other systems CAS is only atomic within a single process.
+
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,102 @@
+package glfsrefs
+
+import (
+	"context"
+	"iter"
+	"slices"
+	"sync"
+
+	"blobcache.io/glfs"
+)
+
+var _ RefStore = &MemStore{}
+
+// MemStore is a RefStore held in memory.
+type MemStore struct {
+	mu   sync.Mutex
+	refs map[string]glfs.Ref
+	// changed is closed, and replaced, whenever a ref changes.
+	changed chan struct{}
+}
+
+func NewMem() *MemStore {
+	return &MemStore{
+		refs:    make(map[string]glfs.Ref),
+		changed: make(chan struct{}),
+	}
+}
+
+func (s *MemStore) Get(ctx context.Context, name string) (*glfs.Ref, error) {
+	if err := CheckName(name); err != nil {
+		return nil, err
+	}
+	ref, _ := s.get(name)
+	return ref, nil
+}
+
+func (s *MemStore) CAS(ctx context.Context, name string, prev, next *glfs.Ref) (bool, error) {
+	if err := CheckName(name); err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var actual *glfs.Ref
+	if ref, exists := s.refs[name]; exists {
+		actual = &ref
+	}
+	if !refEqual(actual, prev) {
+		return false, nil
+	}
+	if next == nil {
+		delete(s.refs, name)
+	} else {
+		s.refs[name] = *next
+	}
+	close(s.changed)
+	s.changed = make(chan struct{})
+	return true, nil
+}
+
+func (s *MemStore) List(ctx context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.refs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+func (s *MemStore) Watch(ctx context.Context, name string) iter.Seq2[*glfs.Ref, error] {
+	return func(yield func(*glfs.Ref, error) bool) {
+		if err := CheckName(name); err != nil {
+			yield(nil, err)
+			return
+		}
+		var last *glfs.Ref
+		for i := 0; ; i++ {
+			ref, changed := s.get(name)
+			if i == 0 || !refEqual(last, ref) {
+				if !yield(ref, nil) {
+					return
+				}
+				last = ref
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+			}
+		}
+	}
+}
+
+func (s *MemStore) get(name string) (*glfs.Ref, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ref, exists := s.refs[name]; exists {
+		return &ref, s.changed
+	}
+	return nil, s.changed
+}
@@ -0,0 +1,227 @@
+package glfs
+
+import (
+	"context"
+	"iter"
+	"path"
+	"slices"
+	"strings"
+
+	"blobcache.io/blobcache/src/schema"
+)
+
+// Glob returns an iterator over the entries beneath root with a path matching pattern.
+// The pattern syntax is the same as path.Match, and additionally a path element of "**" matches zero or more elements.
+// The Name of each yielded entry is its full path relative to root.
+// Trees which cannot contain a match are never read.
+func (ag *Machine) Glob(ctx context.Context, s schema.RO, root Ref, pattern string) iter.Seq2[TreeEntry, error] {
+	return func(yield func(TreeEntry, error) bool) {
+		g, err := compileGlob(pattern)
+		if err != nil {
+			yield(TreeEntry{}, err)
+			return
+		}
+		ag.glob(ctx, s, root, "", g, g.start(), yield)
+	}
+}
+
+func (ag *Machine) glob(ctx context.Context, s schema.RO, root Ref, prefix string, g globPattern, states []int, yield func(TreeEntry, error) bool) bool {
+	for ent, err := range ag.Entries(ctx, s, root) {
+		if err != nil {
+			yield(TreeEntry{}, err)
+			return false
+		}
+		next := g.step(states, ent.Name)
+		if len(next) == 0 {
+			continue
+		}
+		p := path.Join(prefix, ent.Name)
+		if g.matched(next) {
+			if !yield(TreeEntry{Name: p, FileMode: ent.FileMode, Ref: ent.Ref}, nil) {
+				return false
+			}
+		}
+		if ent.Ref.Type == TypeTree && g.alive(next) {
+			if !ag.glob(ctx, s, ent.Ref, p, g, next, yield) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// FilterGlob returns a version of root containing only the paths matched by a pattern in include,
+// and not matched by any pattern in exclude.
+// If include is empty, every path is included.
+// A pattern matching a tree matches everything beneath it.
+// Patterns use the same syntax as Glob.
+//
+// Trees which cannot contain an included path are never read, and trees which are entirely included are reused without being read.
+func (ag *Machine) FilterGlob(ctx context.Context, dst schema.WO, src schema.RO, root Ref, include, exclude []string) (*Ref, error) {
+	var f globFilter
+	for _, pattern := range include {
+		g, err := compileGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.include = append(f.include, g)
+	}
+	for _, pattern := range exclude {
+		g, err := compileGlob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		f.exclude = append(f.exclude, g)
+	}
+	ref, err := ag.filterGlob(ctx, dst, src, root, f, f.start())
+	if err != nil {
+		return nil, err
+	}
+	if ref == nil {
+		return ag.PostTreeSlice(ctx, dst, nil)
+	}
+	return ref, nil
+}
+
+func (ag *Machine) filterGlob(ctx context.Context, dst schema.WO, src schema.RO, root Ref, f globFilter, st globFilterState) (*Ref, error) {
+	var ents2 []TreeEntry
+	for ent, err := range ag.Entries(ctx, src, root) {
+		if err != nil {
+			return nil, err
+		}
+		st2 := f.step(st, ent.Name)
+		switch {
+		case st2.excluded:
+			continue
+		case st2.included && (ent.Ref.Type != TypeTree || !st2.excludeAlive()):
+			ents2 = append(ents2, ent)
+		case ent.Ref.Type == TypeTree && (st2.included || st2.includeAlive()):
+			ref, err := ag.filterGlob(ctx, dst, src, ent.Ref, f, st2)
+			if err != nil {
+				return nil, err
+			}
+			if ref != nil {
+				ent.Ref = *ref
+				ents2 = append(ents2, ent)
+			}
+		}
+	}
+	if len(ents2) == 0 {
+		return nil, nil
+	}
+	if err := ag.syncTreeEntries(ctx, dst, src, ents2); err != nil {
+		return nil, err
+	}
+	return ag.PostTreeSlice(ctx, dst, ents2)
+}
+
+// globPattern is a compiled pattern, split into path elements.
+// Matching a path is done by tracking the set of elements which could match the next part of the path.
+type globPattern []string
+
+func compileGlob(pattern string) (globPattern, error) {
+	parts := strings.Split(CleanPath(pattern), "/")
+	for _, part := range parts {
+		if _, err := path.Match(part, ""); err != nil {
+			return nil, err
+		}
+	}
+	return parts, nil
+}
+
+// start returns the states before any path elements have been consumed.
+func (g globPattern) start() []int {
+	return g.closure([]int{0})
+}
+
+// step returns the states after consuming the path element name.
+func (g globPattern) step(states []int, name string) []int {
+	var next []int
+	for _, i := range states {
+		switch {
+		case i >= len(g):
+		case g[i] == "**":
+			next = append(next, i)
+		default:
+			if ok, _ := path.Match(g[i], name); ok {
+				next = append(next, i+1)
+			}
+		}
+	}
+	return g.closure(next)
+}
+
+// closure adds the states reachable by "**" matching zero elements.
+func (g globPattern) closure(states []int) []int {
+	for i := 0; i < len(states); i++ {
+		if s := states[i]; s < len(g) && g[s] == "**" && !slices.Contains(states, s+1) {
+			states = append(states, s+1)
+		}
+	}
+	slices.Sort(states)
+	return slices.Compact(states)
+}
+
+// matched returns true if the path consumed so far matches the pattern.
+func (g globPattern) matched(states []int) bool {
+	return slices.Contains(states, len(g))
+}
+
+// alive returns true if a longer path could match the pattern.
+func (g globPattern) alive(states []int) bool {
+	return len(states) > 0 && states[0] < len(g)
+}
+
+type globFilter struct {
+	include []globPattern
+	exclude []globPattern
+}
+
+type globFilterState struct {
+	// included is true if the path, or one of its parents, matched an include pattern.
+	included bool
+	// excluded is true if the path, or one of its parents, matched an exclude pattern.
+	excluded bool
+	include  [][]int
+	exclude  [][]int
+}
+
+func (f globFilter) start() globFilterState {
+	st := globFilterState{
+		included: len(f.include) == 0,
+		include:  make([][]int, len(f.include)),
+		exclude:  make([][]int, len(f.exclude)),
+	}
+	for i, g := range f.include {
+		st.include[i] = g.start()
+	}
+	for i, g := range f.exclude {
+		st.exclude[i] = g.start()
+	}
+	return st
+}
+
+func (f globFilter) step(st globFilterState, name string) globFilterState {
+	st2 := globFilterState{
+		included: st.included,
+		include:  make([][]int, len(f.include)),
+		exclude:  make([][]int, len(f.exclude)),
+	}
+	for i, g := range f.include {
+		st2.include[i] = g.step(st.include[i], name)
+		st2.included = st2.included || g.matched(st2.include[i])
+	}
+	for i, g := range f.exclude {
+		st2.exclude[i] = g.step(st.exclude[i], name)
+		st2.excluded = st2.excluded || g.matched(st2.exclude[i])
+	}
+	return st2
+}
+
+func (st globFilterState) includeAlive() bool {
+	return slices.ContainsFunc(st.include, func(states []int) bool { return len(states) > 0 })
+}
+
+func (st globFilterState) excludeAlive() bool {
+	return slices.ContainsFunc(st.exclude, func(states []int) bool { return len(states) > 0 })
+}
@@ -0,0 +1,95 @@
+package glfs
+
+import (
+	"context"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGlob(t *testing.T) {
+	ctx := context.TODO()
+	s := newStore(t)
+	root := mustPostTree(t, s, map[string]Ref{
+		"data/a.parquet":       blobRef(t, s),
+		"data/b.csv":           blobRef(t, s),
+		"data/x/c.parquet":     blobRef(t, s),
+		"data/x/y/d.parquet":   blobRef(t, s),
+		"other/e.parquet":      blobRef(t, s),
+		"top.parquet":          blobRef(t, s),
+		"data/x/y/z/f.parquet": blobRef(t, s),
+	})
+	tcs := []struct {
+		Pattern string
+		Paths   []string
+	}{
+		{"data/**/*.parquet", []string{"data/a.parquet", "data/x/c.parquet", "data/x/y/d.parquet", "data/x/y/z/f.parquet"}},
+		{"data/*.parquet", []string{"data/a.parquet"}},
+		{"**/*.parquet", []string{"data/a.parquet", "data/x/c.parquet", "data/x/y/d.parquet", "data/x/y/z/f.parquet", "other/e.parquet", "top.parquet"}},
+		{"*/x", []string{"data/x"}},
+		{"data/**/y", []string{"data/x/y"}},
+		{"nothing/**", nil},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.Pattern, func(t *testing.T) {
+			var actual []string
+			for ent, err := range defaultOp.Glob(ctx, s, root, tc.Pattern) {
+				require.NoError(t, err)
+				actual = append(actual, ent.Name)
+			}
+			require.Equal(t, tc.Paths, actual)
+		})
+	}
+
+	for _, err := range defaultOp.Glob(ctx, s, root, "[") {
+		require.ErrorIs(t, err, path.ErrBadPattern)
+	}
+}
+
+func TestFilterGlob(t *testing.T) {
+	ctx := context.TODO()
+	s := newStore(t)
+	root := mustPostTree(t, s, map[string]Ref{
+		"data/a.parquet":      blobRef(t, s),
+		"data/b.csv":          blobRef(t, s),
+		"data/tmp/c.parquet":  blobRef(t, s),
+		"data/x/d.parquet":    blobRef(t, s),
+		"other/e.parquet":     blobRef(t, s),
+		"vendor/lib/f.go":     blobRef(t, s),
+		"vendor/lib/g.go.tmp": blobRef(t, s),
+	})
+	tcs := []struct {
+		Include, Exclude []string
+		Paths            []string
+	}{
+		{
+			Include: []string{"data/**/*.parquet"},
+			Exclude: []string{"data/tmp"},
+			Paths:   []string{"data/a.parquet", "data/x/d.parquet"},
+		},
+		{
+			Exclude: []string{"data", "**/*.tmp"},
+			Paths:   []string{"other/e.parquet", "vendor/lib/f.go"},
+		},
+		{
+			Include: []string{"vendor"},
+			Paths:   []string{"vendor/lib/f.go", "vendor/lib/g.go.tmp"},
+		},
+		{
+			Include: []string{"nothing"},
+		},
+	}
+	for _, tc := range tcs {
+		ref, err := FilterGlob(ctx, s, s, root, tc.Include, tc.Exclude)
+		require.NoError(t, err)
+		var actual []string
+		require.NoError(t, WalkTree(ctx, s, *ref, func(prefix string, ent TreeEntry) error {
+			if ent.Ref.Type != TypeTree {
+				actual = append(actual, path.Join(prefix, ent.Name))
+			}
+			return nil
+		}))
+		require.Equal(t, tc.Paths, actual)
+	}
+}
@@ -0,0 +1,36 @@
+// Package glfstest provides fixtures for the tests of packages which use glfs.
+package glfstest
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"testing"
+
+	"blobcache.io/blobcache/src/blobcache"
+	"blobcache.io/blobcache/src/schema"
+	"github.com/stretchr/testify/require"
+
+	"blobcache.io/glfs"
+)
+
+// NewStore returns an empty in-memory store, with the hash function and block size used by glfs by default.
+func NewStore() *schema.MemStore {
+	return schema.NewMem(blobcache.HashAlgo_BLAKE3_256.HashFunc(), glfs.DefaultBlockSize)
+}
+
+// GenerateTree posts a tree of n small blobs to s, in directories of 10, and returns its root.
+// The blobs contain their own paths, so they are all different.
+func GenerateTree(t testing.TB, ag *glfs.Machine, s schema.WO, n int) glfs.Ref {
+	ctx := context.TODO()
+	m := map[string]glfs.Ref{}
+	for i := 0; i < n; i++ {
+		p := strconv.Itoa(i/10) + "/" + strconv.Itoa(i)
+		ref, err := ag.PostBlob(ctx, s, bytes.NewReader([]byte(p)))
+		require.NoError(t, err)
+		m[p] = *ref
+	}
+	ref, err := ag.PostTreeMap(ctx, s, m)
+	require.NoError(t, err)
+	return *ref
+}
@@ -0,0 +1,22 @@
+// Package hexcid converts CIDs to and from hex, as they appear in paths and URLs.
+package hexcid
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"blobcache.io/blobcache/src/blobcache"
+)
+
+// Parse parses a hex encoded CID.
+func Parse(x string) (blobcache.CID, error) {
+	var cid blobcache.CID
+	data, err := hex.DecodeString(x)
+	if err != nil {
+		return cid, err
+	}
+	if len(data) != len(cid) {
+		return cid, fmt.Errorf("cid has wrong length %d", len(data))
+	}
+	return blobcache.CID(data), nil
+}
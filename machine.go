@@ -2,8 +2,11 @@ package glfs
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"iter"
+	"maps"
+	"runtime"
 
 	"blobcache.io/blobcache/src/schema"
 	"blobcache.io/glfs/bigblob"
@@ -18,6 +21,47 @@ func WithSalt(salt [32]byte) Option {
 	}
 }
 
+// AutoBlockSize can be passed to WithBlockSize, to use the MaxSize of each store as the block size.
+const AutoBlockSize = 0
+
+// WithBlockSize sets the block size used when writing objects.
+// n must be AutoBlockSize, or a power of two which is at least 2*bigblob.RefSize, otherwise WithBlockSize panics.
+// If the store being written to has a MaxSize smaller than n, then the largest power of two which fits in the store is used instead.
+// If n is AutoBlockSize, then the same is done with the store's MaxSize.
+// The default is DefaultBlockSize.
+//
+// Writing the same data with different block sizes will produce different Refs.
+func WithBlockSize(n int) Option {
+	if n < 0 || n > 0 && (n < 2*bigblob.RefSize || n&(n-1) != 0) {
+		panic(fmt.Sprintf("block size must be AutoBlockSize, or a power of two >= %d: %d", 2*bigblob.RefSize, n))
+	}
+	return func(ag *Machine) {
+		ag.blockSize = n
+	}
+}
+
+// WithCacheSize sets the number of decrypted blocks which the Machine will cache.
+// The default is 64.
+func WithCacheSize(n int) Option {
+	if n < 1 {
+		panic(n)
+	}
+	return func(ag *Machine) {
+		ag.cacheSize = n
+	}
+}
+
+// WithConcurrency sets the number of goroutines the Machine will use for operations which can be done in parallel.
+// The default is runtime.GOMAXPROCS(0).
+func WithConcurrency(n int) Option {
+	if n < 1 {
+		panic(n)
+	}
+	return func(ag *Machine) {
+		ag.concurrency = n
+	}
+}
+
 // Machine holds a configuration, and caches.
 // Machine configuration is immutable once it is created.
 // Any cache state should be transparent to the user, so the Machine
@@ -32,18 +76,31 @@ func WithSalt(salt [32]byte) Option {
 // This is because each hop must be read from the store and decrypted, the decrypted plaintext
 // will be cached by the machine.
 type Machine struct {
-	salt      *[32]byte
-	blockSize int
+	salt        *[32]byte
+	blockSize   int
+	cacheSize   int
+	concurrency int
+	types       map[Type]TypeSpec
 
 	bbag *bigblob.Machine
 }
 
 func NewMachine(opts ...Option) *Machine {
 	o := &Machine{
-		salt:      new([32]byte),
-		blockSize: DefaultBlockSize,
+		salt:        new([32]byte),
+		blockSize:   DefaultBlockSize,
+		cacheSize:   64,
+		concurrency: runtime.GOMAXPROCS(0),
+		types:       map[Type]TypeSpec{},
 	}
-	o.bbag = bigblob.NewMachine(bigblob.WithBlockSize(o.blockSize))
+	for _, opt := range opts {
+		opt(o)
+	}
+	maps.Copy(o.types, builtinTypes(o))
+	o.bbag = bigblob.NewMachine(
+		bigblob.WithBlockSize(o.blockSize),
+		bigblob.WithCacheSize(o.cacheSize),
+	)
 	return o
 }
 
@@ -65,6 +122,11 @@ func PostBlob(ctx context.Context, s schema.WO, r io.Reader) (*Ref, error) {
 	return defaultOp.PostBlob(ctx, s, r)
 }
 
+// Append calls Append on the default Machine
+func Append(ctx context.Context, s schema.RW, x Ref, r io.Reader) (*Ref, error) {
+	return defaultOp.Append(ctx, s, x, r)
+}
+
 // GetBlob returns an io.ReadSeeker for accessing data from the blob at x
 func GetBlob(ctx context.Context, s schema.RO, x Ref) (*Reader, error) {
 	return defaultOp.GetBlob(ctx, s, x)
@@ -87,6 +149,16 @@ func PostTreeMap(ctx context.Context, s schema.WO, m map[string]Ref) (*Ref, erro
 	return defaultOp.PostTreeMap(ctx, s, m)
 }
 
+// PostCommit calls PostCommit on the default Machine
+func PostCommit(ctx context.Context, s schema.WO, c Commit) (*Ref, error) {
+	return defaultOp.PostCommit(ctx, s, c)
+}
+
+// GetCommit calls GetCommit on the default Machine
+func GetCommit(ctx context.Context, s schema.RO, ref Ref) (*Commit, error) {
+	return defaultOp.GetCommit(ctx, s, ref)
+}
+
 // GetTreeSlice retreives the tree in store at Ref if it exists.
 // If ref.Type != TypeTree ErrRefType is returned.
 func GetTreeSlice(ctx context.Context, store schema.RO, ref Ref, maxEnts int) ([]TreeEntry, error) {
@@ -107,7 +179,9 @@ func WalkTree(ctx context.Context, store schema.RO, ref Ref, f WalkTreeFunc) err
 }
 
 // WalkRefs calls fn with every Ref reacheable from ref, including Ref. The only guarentee about order is bottom up.
-// if a tree is encoutered the child refs will be visited first.
+// if a tree, commit, or other registered type is encoutered the child refs will be visited first.
+// Each commit is visited once, even if it is reachable through more than one merge.
+// An error is returned if an object of a Type which has not been registered with the Machine is reachable from ref.
 func WalkRefs(ctx context.Context, s schema.RO, ref Ref, fn RefWalker) error {
 	return defaultOp.WalkRefs(ctx, s, ref, fn)
 }
@@ -124,6 +198,11 @@ func FilterPaths(ctx context.Context, dst schema.WO, src schema.RO, root Ref, f
 	return defaultOp.FilterPaths(ctx, dst, src, root, f)
 }
 
+// FilterGlob calls FilterGlob on the default Machine
+func FilterGlob(ctx context.Context, dst schema.WO, src schema.RO, root Ref, include, exclude []string) (*Ref, error) {
+	return defaultOp.FilterGlob(ctx, dst, src, root, include, exclude)
+}
+
 // ShardLeaves calls ShardLeaves on the default Machine
 func ShardLeaves(ctx context.Context, dst schema.WO, s schema.RO, root Ref, n int) ([]Ref, error) {
 	return defaultOp.ShardLeaves(ctx, dst, s, root, n)
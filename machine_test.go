@@ -0,0 +1,64 @@
+package glfs
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"blobcache.io/blobcache/src/blobcache"
+	"blobcache.io/blobcache/src/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockSize(t *testing.T) {
+	ctx := context.TODO()
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1<<12)
+	small := schema.NewMem(blobcache.HashAlgo_BLAKE3_256.HashFunc(), 1<<12)
+	large := newStore(t)
+	// a MaxSize which is not a power of two, data is written in 512 byte blocks, with more than one level of index.
+	odd := schema.NewMem(blobcache.HashAlgo_BLAKE3_256.HashFunc(), 1000)
+
+	tcs := []struct {
+		Name    string
+		Machine *Machine
+		Store   *schema.MemStore
+		Want    uint64
+	}{
+		// the default block size is larger than the store's MaxSize
+		{Name: "DefaultSmall", Machine: NewMachine(), Store: small, Want: 1 << 12},
+		{Name: "DefaultLarge", Machine: NewMachine(), Store: large, Want: DefaultBlockSize},
+		{Name: "Explicit", Machine: NewMachine(WithBlockSize(1 << 13)), Store: large, Want: 1 << 13},
+		{Name: "AutoSmall", Machine: NewMachine(WithBlockSize(AutoBlockSize)), Store: small, Want: 1 << 12},
+		{Name: "AutoLarge", Machine: NewMachine(WithBlockSize(AutoBlockSize)), Store: large, Want: DefaultBlockSize},
+		{Name: "DefaultOdd", Machine: NewMachine(), Store: odd, Want: 512},
+		{Name: "ExplicitOdd", Machine: NewMachine(WithBlockSize(1 << 10)), Store: odd, Want: 512},
+		{Name: "AutoOdd", Machine: NewMachine(WithBlockSize(AutoBlockSize)), Store: odd, Want: 512},
+	}
+	for _, tc := range tcs {
+		t.Run(tc.Name, func(t *testing.T) {
+			ref, err := tc.Machine.PostBlob(ctx, tc.Store, bytes.NewReader(data))
+			require.NoError(t, err)
+			require.Equal(t, tc.Want, ref.BlockSize)
+			actual, err := tc.Machine.GetBlobBytes(ctx, tc.Store, *ref, len(data))
+			require.NoError(t, err)
+			require.Equal(t, data, actual)
+		})
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	require.Panics(t, func() { WithBlockSize(1000) })
+	require.Panics(t, func() { WithBlockSize(64) })
+	require.Panics(t, func() { WithCacheSize(0) })
+	require.Panics(t, func() { WithConcurrency(0) })
+}
+
+func TestConcurrency(t *testing.T) {
+	ctx := context.TODO()
+	ag := NewMachine(WithConcurrency(1), WithCacheSize(1))
+	src := newStore(t)
+	root := mustPostTree(t, src, generateTree(t, src, 100))
+	dst := newStore(t)
+	require.NoError(t, ag.Sync(ctx, dst, src, root))
+	require.NoError(t, ag.Verify(ctx, dst, root))
+}
@@ -0,0 +1,249 @@
+package glfs
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"slices"
+
+	"blobcache.io/blobcache/src/schema"
+)
+
+// ConflictKind describes how the two sides of a Merge3 disagree about a path.
+type ConflictKind string
+
+const (
+	// ConflictModifyModify means both sides changed the entry, in different ways.
+	ConflictModifyModify = ConflictKind("modify/modify")
+	// ConflictModifyDelete means one side changed the entry, and the other side removed it.
+	ConflictModifyDelete = ConflictKind("modify/delete")
+	// ConflictAddAdd means both sides added an entry which was not in the base, with different contents.
+	ConflictAddAdd = ConflictKind("add/add")
+	// ConflictType means the sides disagree about the type of the entry. e.g. one side has a tree, the other a blob.
+	ConflictType = ConflictKind("type")
+)
+
+// Conflict is a path which was changed by both sides of a Merge3, in a way that could not be reconciled.
+// Base, Ours, and Theirs are nil if the path does not exist on that side.
+type Conflict struct {
+	Path string
+	Kind ConflictKind
+
+	Base, Ours, Theirs *TreeEntry
+}
+
+func (c Conflict) String() string {
+	return fmt.Sprintf("%s: %s", c.Kind, c.Path)
+}
+
+// ConflictResolver is called by Merge3 for each conflict.
+// If ok is true, ent is used at the conflicting path; if ent is nil the path is removed.
+// If ok is false, the conflict is left unresolved.
+// The Name of the returned entry is ignored.
+type ConflictResolver func(ctx context.Context, c Conflict) (ent *TreeEntry, ok bool, err error)
+
+// ResolveOurs is a ConflictResolver which always chooses ours.
+func ResolveOurs(ctx context.Context, c Conflict) (*TreeEntry, bool, error) {
+	return c.Ours, true, nil
+}
+
+// ResolveTheirs is a ConflictResolver which always chooses theirs.
+func ResolveTheirs(ctx context.Context, c Conflict) (*TreeEntry, bool, error) {
+	return c.Theirs, true, nil
+}
+
+// Merge3Result is the result of a Merge3
+type Merge3Result struct {
+	Root Ref
+	// Conflicts are the conflicts which were not resolved.
+	// At each of these paths Root contains the side which was not deleted, or ours if both sides exist.
+	Conflicts []Conflict
+}
+
+// Merge3 performs a three-way merge of the changes from base to ours, and from base to theirs.
+// Additions, deletions, and modifications made by only one side are applied.
+// Subtrees which are equal on both sides, or unchanged on one side, are not read.
+//
+// Paths changed by both sides are passed to resolve, which may be nil.
+// The conflicts which are not resolved are returned in the result.
+//
+// Merge3 will call Sync to protect referential integrity in dst.
+func (ag *Machine) Merge3(ctx context.Context, dst schema.WO, src schema.RO, base, ours, theirs Ref, resolve ConflictResolver) (*Merge3Result, error) {
+	m := merger3{ag: ag, dst: dst, src: src, resolve: resolve}
+	ent, err := m.mergeEntry(ctx, "", &TreeEntry{Ref: base, FileMode: getFileMode(base)}, &TreeEntry{Ref: ours, FileMode: getFileMode(ours)}, &TreeEntry{Ref: theirs, FileMode: getFileMode(theirs)})
+	if err != nil {
+		return nil, err
+	}
+	if ent == nil {
+		return nil, fmt.Errorf("merge3: root was removed by conflict resolution")
+	}
+	if err := ag.Sync(ctx, dst, src, ent.Ref); err != nil {
+		return nil, err
+	}
+	return &Merge3Result{Root: ent.Ref, Conflicts: m.conflicts}, nil
+}
+
+type merger3 struct {
+	ag      *Machine
+	dst     schema.WO
+	src     schema.RO
+	resolve ConflictResolver
+
+	conflicts []Conflict
+}
+
+// mergeEntry returns the merged entry at p, or nil if the entry should not exist.
+func (m *merger3) mergeEntry(ctx context.Context, p string, base, ours, theirs *TreeEntry) (*TreeEntry, error) {
+	switch {
+	case entryEqual(ours, theirs):
+		return ours, nil
+	case entryEqual(base, ours):
+		return theirs, nil
+	case entryEqual(base, theirs):
+		return ours, nil
+	}
+	// both sides changed the entry
+	c := Conflict{Path: p, Base: base, Ours: ours, Theirs: theirs}
+	switch {
+	case ours == nil || theirs == nil:
+		c.Kind = ConflictModifyDelete
+	case ours.Ref.Type != theirs.Ref.Type:
+		c.Kind = ConflictType
+	case ours.Ref.Type == TypeTree && !ours.Ref.Equals(theirs.Ref) && (base == nil || base.Ref.Type == TypeTree):
+		var baseRef *Ref
+		if base != nil {
+			baseRef = &base.Ref
+		}
+		ref, err := m.mergeTrees(ctx, p, baseRef, ours.Ref, theirs.Ref)
+		if err != nil {
+			return nil, err
+		}
+		if mode, ok := mergeMode(base, ours, theirs); ok {
+			return &TreeEntry{Name: ours.Name, FileMode: mode, Ref: *ref}, nil
+		}
+		// the contents were merged, but the modes conflict.
+		c.Kind = ConflictModifyModify
+		c.Ours = &TreeEntry{Name: ours.Name, FileMode: ours.FileMode, Ref: *ref}
+		c.Theirs = &TreeEntry{Name: theirs.Name, FileMode: theirs.FileMode, Ref: *ref}
+		ours = c.Ours
+	default:
+		// the contents and the mode are merged independently.
+		ref, refOK := mergeRef(base, ours, theirs)
+		mode, modeOK := mergeMode(base, ours, theirs)
+		switch {
+		case refOK && modeOK:
+			return &TreeEntry{Name: ours.Name, FileMode: mode, Ref: ref}, nil
+		case base == nil:
+			c.Kind = ConflictAddAdd
+		case base.Ref.Type != ours.Ref.Type:
+			c.Kind = ConflictType
+		default:
+			c.Kind = ConflictModifyModify
+		}
+	}
+	if m.resolve != nil {
+		ent, ok, err := m.resolve(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			if ent != nil {
+				ent2 := *ent
+				ent2.Name = entryName(ours, theirs)
+				ent = &ent2
+			}
+			return ent, nil
+		}
+	}
+	m.conflicts = append(m.conflicts, c)
+	if ours != nil {
+		return ours, nil
+	}
+	return theirs, nil
+}
+
+func (m *merger3) mergeTrees(ctx context.Context, p string, base *Ref, ours, theirs Ref) (*Ref, error) {
+	var baseTree []TreeEntry
+	if base != nil {
+		var err error
+		if baseTree, err = m.ag.GetTreeSlice(ctx, m.src, *base, 1e6); err != nil {
+			return nil, err
+		}
+	}
+	ourTree, err := m.ag.GetTreeSlice(ctx, m.src, ours, 1e6)
+	if err != nil {
+		return nil, err
+	}
+	theirTree, err := m.ag.GetTreeSlice(ctx, m.src, theirs, 1e6)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]struct{}{}
+	for _, tree := range [][]TreeEntry{baseTree, ourTree, theirTree} {
+		for _, ent := range tree {
+			names[ent.Name] = struct{}{}
+		}
+	}
+	var ents []TreeEntry
+	// names are merged in order, so that conflicts are reported in order.
+	for _, name := range slices.Sorted(maps.Keys(names)) {
+		ent, err := m.mergeEntry(ctx, path.Join(p, name), Lookup(baseTree, name), Lookup(ourTree, name), Lookup(theirTree, name))
+		if err != nil {
+			return nil, err
+		}
+		if ent != nil {
+			ents = append(ents, *ent)
+		}
+	}
+	SortTreeEntries(ents)
+	if err := m.ag.syncTreeEntries(ctx, m.dst, m.src, ents); err != nil {
+		return nil, err
+	}
+	return m.ag.PostTreeSlice(ctx, m.dst, ents)
+}
+
+// entryEqual returns true if a and b are both nil, or have the same mode and Ref.
+func entryEqual(a, b *TreeEntry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.FileMode == b.FileMode && a.Ref.Equals(b.Ref)
+}
+
+// mergeRef performs a three-way merge of the Refs of the entries, without reading them.
+func mergeRef(base, ours, theirs *TreeEntry) (Ref, bool) {
+	switch {
+	case ours.Ref.Equals(theirs.Ref):
+		return ours.Ref, true
+	case base != nil && base.Ref.Equals(ours.Ref):
+		return theirs.Ref, true
+	case base != nil && base.Ref.Equals(theirs.Ref):
+		return ours.Ref, true
+	default:
+		return Ref{}, false
+	}
+}
+
+// mergeMode performs a three-way merge of the modes of the entries.
+func mergeMode(base, ours, theirs *TreeEntry) (os.FileMode, bool) {
+	switch {
+	case ours.FileMode == theirs.FileMode:
+		return ours.FileMode, true
+	case base != nil && base.FileMode == ours.FileMode:
+		return theirs.FileMode, true
+	case base != nil && base.FileMode == theirs.FileMode:
+		return ours.FileMode, true
+	default:
+		return 0, false
+	}
+}
+
+// entryName returns the name of whichever of a or b is not nil.
+func entryName(a, b *TreeEntry) string {
+	if a != nil {
+		return a.Name
+	}
+	return b.Name
+}
@@ -0,0 +1,130 @@
+package glfs
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerge3(t *testing.T) {
+	ctx := context.TODO()
+	s := newStore(t)
+	a1 := mustPostBlob(t, s, []byte("a1"))
+	a2 := mustPostBlob(t, s, []byte("a2"))
+	b1 := mustPostBlob(t, s, []byte("b1"))
+	c1 := mustPostBlob(t, s, []byte("c1"))
+	d1 := mustPostBlob(t, s, []byte("d1"))
+	e1 := mustPostBlob(t, s, []byte("e1"))
+
+	base := mustPostTree(t, s, map[string]Ref{
+		"dir/a": a1,
+		"dir/b": b1,
+		"c":     c1,
+	})
+	ours := mustPostTree(t, s, map[string]Ref{
+		"dir/a": a2,
+		"dir/b": b1,
+		"c":     c1,
+		"d":     d1,
+	})
+	theirs := mustPostTree(t, s, map[string]Ref{
+		"dir/a": a1,
+		"e":     e1,
+	})
+	res, err := defaultOp.Merge3(ctx, s, s, base, ours, theirs, nil)
+	require.NoError(t, err)
+	require.Empty(t, res.Conflicts)
+	expected := mustPostTree(t, s, map[string]Ref{
+		"dir/a": a2,
+		"d":     d1,
+		"e":     e1,
+	})
+	require.Equal(t, expected, res.Root)
+}
+
+func TestMerge3Conflicts(t *testing.T) {
+	ctx := context.TODO()
+	s := newStore(t)
+	x1 := mustPostBlob(t, s, []byte("x1"))
+	x2 := mustPostBlob(t, s, []byte("x2"))
+	x3 := mustPostBlob(t, s, []byte("x3"))
+
+	base := mustPostTree(t, s, map[string]Ref{
+		"mm": x1,
+		"md": x1,
+		"ty": x1,
+	})
+	ours := mustPostTree(t, s, map[string]Ref{
+		"mm": x2,
+		"md": x2,
+		"ty": mustPostTree(t, s, map[string]Ref{"x": x1}),
+		"aa": x2,
+	})
+	theirs := mustPostTree(t, s, map[string]Ref{
+		"mm": x3,
+		"ty": x3,
+		"aa": x3,
+	})
+	res, err := defaultOp.Merge3(ctx, s, s, base, ours, theirs, nil)
+	require.NoError(t, err)
+	var paths []string
+	kinds := map[string]ConflictKind{}
+	for _, c := range res.Conflicts {
+		paths = append(paths, c.Path)
+		kinds[c.Path] = c.Kind
+	}
+	require.Equal(t, map[string]ConflictKind{
+		"mm": ConflictModifyModify,
+		"md": ConflictModifyDelete,
+		"ty": ConflictType,
+		"aa": ConflictAddAdd,
+	}, kinds)
+	// conflicts are reported in path order.
+	require.Equal(t, []string{"aa", "md", "mm", "ty"}, paths)
+	require.Equal(t, ours, res.Root)
+
+	res, err = defaultOp.Merge3(ctx, s, s, base, ours, theirs, ResolveTheirs)
+	require.NoError(t, err)
+	require.Empty(t, res.Conflicts)
+	require.Equal(t, theirs, res.Root)
+}
+
+func TestMerge3Modes(t *testing.T) {
+	ctx := context.TODO()
+	s := newStore(t)
+	a := mustPostBlob(t, s, []byte("a"))
+	b := mustPostBlob(t, s, []byte("b"))
+	tree := func(ents ...TreeEntry) Ref {
+		ref, err := defaultOp.PostTreeSlice(ctx, s, ents)
+		require.NoError(t, err)
+		return *ref
+	}
+	dir := func(mode os.FileMode, names ...string) TreeEntry {
+		m := map[string]Ref{}
+		for _, name := range names {
+			m[name] = a
+		}
+		return TreeEntry{Name: "dir", FileMode: os.ModeDir | mode, Ref: mustPostTree(t, s, m)}
+	}
+
+	// ours only changes the mode of f, and theirs only changes its contents.
+	// both change the contents of dir, and its mode in different ways.
+	base := tree(TreeEntry{Name: "f", FileMode: 0o644, Ref: a}, dir(0o755, "x"))
+	ours := tree(TreeEntry{Name: "f", FileMode: 0o755, Ref: a}, dir(0o700, "x", "y"))
+	theirs := tree(TreeEntry{Name: "f", FileMode: 0o644, Ref: b}, dir(0o750, "x", "z"))
+	res, err := defaultOp.Merge3(ctx, s, s, base, ours, theirs, nil)
+	require.NoError(t, err)
+	require.Len(t, res.Conflicts, 1)
+	require.Equal(t, "dir", res.Conflicts[0].Path)
+	require.Equal(t, ConflictModifyModify, res.Conflicts[0].Kind)
+	expected := tree(TreeEntry{Name: "f", FileMode: 0o755, Ref: b}, dir(0o700, "x", "y", "z"))
+	require.Equal(t, expected, res.Root)
+
+	res, err = defaultOp.Merge3(ctx, s, s, base, ours, theirs, ResolveTheirs)
+	require.NoError(t, err)
+	require.Empty(t, res.Conflicts)
+	expected = tree(TreeEntry{Name: "f", FileMode: 0o755, Ref: b}, dir(0o750, "x", "y", "z"))
+	require.Equal(t, expected, res.Root)
+}
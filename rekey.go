@@ -0,0 +1,107 @@
+package glfs
+
+import (
+	"context"
+	"fmt"
+
+	"blobcache.io/blobcache/src/blobcache"
+	"blobcache.io/blobcache/src/schema"
+)
+
+// Rekey rewrites every object reachable from root using newMachine, which can have a different salt.
+// Data is read from src using ag, and written to dst using newMachine.
+// Each unique object is only rewritten once, even if it is referenced from many places.
+//
+// Objects of registered types other than blobs, trees, and commits, can only be rekeyed if they do not contain Refs.
+func (ag *Machine) Rekey(ctx context.Context, dst schema.WO, src schema.RO, root Ref, newMachine *Machine) (*Ref, error) {
+	rk := rekeyer{
+		src:  src,
+		dst:  dst,
+		from: ag,
+		to:   newMachine,
+		memo: map[blobcache.CID]Ref{},
+	}
+	return rk.rekey(ctx, root)
+}
+
+type rekeyer struct {
+	src      schema.RO
+	dst      schema.WO
+	from, to *Machine
+	memo     map[blobcache.CID]Ref
+}
+
+func (rk *rekeyer) rekey(ctx context.Context, x Ref) (*Ref, error) {
+	if y, exists := rk.memo[x.CID]; exists {
+		return &y, nil
+	}
+	var y *Ref
+	var err error
+	switch x.Type {
+	case TypeTree:
+		y, err = rk.rekeyTree(ctx, x)
+	case TypeCommit:
+		y, err = rk.rekeyCommit(ctx, x)
+	default:
+		y, err = rk.rekeyOpaque(ctx, x)
+	}
+	if err != nil {
+		return nil, err
+	}
+	rk.memo[x.CID] = *y
+	return y, nil
+}
+
+func (rk *rekeyer) rekeyTree(ctx context.Context, x Ref) (*Ref, error) {
+	var ents []TreeEntry
+	for ent, err := range rk.from.Entries(ctx, rk.src, x) {
+		if err != nil {
+			return nil, err
+		}
+		ref, err := rk.rekey(ctx, ent.Ref)
+		if err != nil {
+			return nil, err
+		}
+		ent.Ref = *ref
+		ents = append(ents, ent)
+	}
+	return rk.to.PostTreeSlice(ctx, rk.dst, ents)
+}
+
+func (rk *rekeyer) rekeyCommit(ctx context.Context, x Ref) (*Ref, error) {
+	c, err := rk.from.GetCommit(ctx, rk.src, x)
+	if err != nil {
+		return nil, err
+	}
+	root, err := rk.rekey(ctx, c.Root)
+	if err != nil {
+		return nil, err
+	}
+	c.Root = *root
+	for i, parent := range c.Parents {
+		ref, err := rk.rekey(ctx, parent)
+		if err != nil {
+			return nil, err
+		}
+		c.Parents[i] = *ref
+	}
+	return rk.to.PostCommit(ctx, rk.dst, *c)
+}
+
+// rekeyOpaque rewrites an object which does not contain any Refs, without interpretting it.
+func (rk *rekeyer) rekeyOpaque(ctx context.Context, x Ref) (*Ref, error) {
+	if x.Type != TypeBlob {
+		spec, err := rk.from.typeSpec(x.Type)
+		if err != nil {
+			return nil, err
+		}
+		if spec.Refs != nil {
+			return nil, fmt.Errorf("cannot rekey objects of type %s, which contain Refs", x.Type)
+		}
+	}
+	r, err := rk.from.GetTyped(ctx, rk.src, x.Type, x)
+	if err != nil {
+		return nil, err
+	}
+	return rk.to.PostTyped(ctx, rk.dst, x.Type, r)
+}
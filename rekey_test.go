@@ -0,0 +1,59 @@
+package glfs
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"blobcache.io/blobcache/src/schema"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSalt(t *testing.T) {
+	s := newStore(t)
+	ag1 := NewMachine()
+	ag2 := NewMachine(WithSalt([32]byte{1}))
+	ref1 := mustPostBlobWith(t, ag1, s, "hello")
+	ref2 := mustPostBlobWith(t, ag2, s, "hello")
+	require.NotEqual(t, ref1.CID, ref2.CID)
+	// the salt is only needed for writing, any Machine can read.
+	data, err := ag1.GetBlobBytes(context.TODO(), s, ref2, 100)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+}
+
+func TestRekey(t *testing.T) {
+	ctx := context.TODO()
+	ag1 := NewMachine()
+	ag2 := NewMachine(WithSalt([32]byte{1}))
+	post := func(ag *Machine, s *schema.MemStore) Ref {
+		shared := mustPostBlobWith(t, ag, s, "shared")
+		ref, err := ag.PostTreeMap(ctx, s, map[string]Ref{
+			"a.txt":     mustPostBlobWith(t, ag, s, "a"),
+			"b/c.txt":   shared,
+			"b/d/e.txt": shared,
+		})
+		require.NoError(t, err)
+		c, err := ag.PostCommit(ctx, s, Commit{Root: *ref, Time: time.Unix(0, 0).UTC()})
+		require.NoError(t, err)
+		return *c
+	}
+	src := newStore(t)
+	root1 := post(ag1, src)
+
+	dst := newStore(t)
+	root2, err := ag1.Rekey(ctx, dst, src, root1, ag2)
+	require.NoError(t, err)
+	require.NotEqual(t, root1.CID, root2.CID)
+	require.NoError(t, ag2.Verify(ctx, dst, *root2))
+	// rekeying produces the same data as writing with the new Machine in the first place.
+	require.Equal(t, post(ag2, newStore(t)), *root2)
+	require.Equal(t, src.Len(), dst.Len())
+}
+
+func mustPostBlobWith(t testing.TB, ag *Machine, s schema.WO, data string) Ref {
+	ref, err := ag.PostBlob(context.TODO(), s, strings.NewReader(data))
+	require.NoError(t, err)
+	return *ref
+}
@@ -7,11 +7,20 @@ import (
 	"blobcache.io/blobcache/src/schema"
 	"go.brendoncarroll.net/exp/streams"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 )
 
 // Sync ensures that all data referenced by x exists in dst, copying from src if necessary.
 // Sync assumes there are no dangling references, and skips copying data when its existence is implied.
+// Objects of a Type which has not been registered with the Machine cannot be synced.
+// At most the Machine's concurrency limit of objects are synced in parallel.
 func (ag *Machine) Sync(ctx context.Context, dst schema.WO, src schema.RO, x Ref) error {
+	// the caller's goroutine is one of the workers.
+	sem := semaphore.NewWeighted(int64(ag.concurrency - 1))
+	return ag.sync(ctx, dst, src, sem, x)
+}
+
+func (ag *Machine) sync(ctx context.Context, dst schema.WO, src schema.RO, sem *semaphore.Weighted, x Ref) error {
 	switch x.Type {
 	case TypeBlob:
 		return ag.bbag.Sync(ctx, dst, src, x.Root, func(r *Reader) error { return nil })
@@ -27,15 +36,43 @@ func (ag *Machine) Sync(ctx context.Context, dst schema.WO, src schema.RO, x Ref
 					}
 					return err
 				}
-				group.Go(func() error {
-					return ag.Sync(ctx2, dst, src, ent.Ref)
-				})
+				if err := ag.syncChild(ctx2, group, dst, src, sem, ent.Ref); err != nil {
+					return err
+				}
 			}
 			return group.Wait()
 		})
 	default:
-		return fmt.Errorf("can't sync unrecognized type %s", x.Type)
+		spec, err := ag.typeSpec(x.Type)
+		if err != nil {
+			return fmt.Errorf("can't sync: %w", err)
+		}
+		return ag.bbag.Sync(ctx, dst, src, x.Root, func(r *Reader) error {
+			refs, err := readRefs(spec, r)
+			if err != nil {
+				return err
+			}
+			group, ctx2 := errgroup.WithContext(ctx)
+			for _, ref := range refs {
+				if err := ag.syncChild(ctx2, group, dst, src, sem, ref); err != nil {
+					return err
+				}
+			}
+			return group.Wait()
+		})
+	}
+}
+
+// syncChild syncs x in a new goroutine in group if sem has capacity, and otherwise on the calling goroutine.
+func (ag *Machine) syncChild(ctx context.Context, group *errgroup.Group, dst schema.WO, src schema.RO, sem *semaphore.Weighted, x Ref) error {
+	if sem.TryAcquire(1) {
+		group.Go(func() error {
+			defer sem.Release(1)
+			return ag.sync(ctx, dst, src, sem, x)
+		})
+		return nil
 	}
+	return ag.sync(ctx, dst, src, sem, x)
 }
 
 // syncTreeEntries is a convenience function for syncing tree entries.
@@ -2,6 +2,7 @@ package glfs
 
 import (
 	"context"
+	"fmt"
 
 	"blobcache.io/blobcache/src/blobcache"
 	"blobcache.io/blobcache/src/schema"
@@ -17,10 +18,11 @@ type Traverser struct {
 	Exit func(ctx context.Context, ty Type, level int, ref bigblob.Ref) error
 }
 
+// Traverse visits every block reachable from x, calling tr.Exit on each block after everything it references.
+// If sem is nil, everything is visited sequentially, on the calling goroutine.
+// Otherwise subtrees are visited in parallel while sem has capacity, and tr must be safe to call from multiple goroutines.
+// An error is returned if an object of a Type which has not been registered with the Machine is reachable from x.
 func (ag *Machine) Traverse(ctx context.Context, s schema.RO, sem *semaphore.Weighted, x Ref, tr Traverser) error {
-	if sem == nil {
-		sem = semaphore.NewWeighted(1)
-	}
 	if yes, err := tr.Enter(ctx, x.CID); err != nil {
 		return err
 	} else if !yes {
@@ -40,7 +42,7 @@ func (ag *Machine) Traverse(ctx context.Context, s schema.RO, sem *semaphore.Wei
 			fn := func() error {
 				return ag.Traverse(ctx, s, sem, ent.Ref, tr)
 			}
-			if sem.TryAcquire(1) {
+			if sem != nil && sem.TryAcquire(1) {
 				eg.Go(func() error {
 					defer sem.Release(1)
 					return fn()
@@ -54,6 +56,16 @@ func (ag *Machine) Traverse(ctx context.Context, s schema.RO, sem *semaphore.Wei
 		if err := eg.Wait(); err != nil {
 			return err
 		}
+	default:
+		refs, err := ag.ChildRefs(ctx, s, x)
+		if err != nil {
+			return fmt.Errorf("can't traverse: %w", err)
+		}
+		for _, ref := range refs {
+			if err := ag.Traverse(ctx, s, sem, ref, tr); err != nil {
+				return err
+			}
+		}
 	}
 	return ag.bbag.Traverse(ctx, s, sem, x.Root, bigblob.Traverser{
 		Enter: tr.Enter,
@@ -62,3 +74,10 @@ func (ag *Machine) Traverse(ctx context.Context, s schema.RO, sem *semaphore.Wei
 		},
 	})
 }
+
+// TraverseBlocks calls exit with every block in the object at x, including index blocks.
+// Unlike Traverse, it does not visit the objects referenced by x.
+// If enter returns false for a block, the block and the blocks beneath it are skipped.
+func (ag *Machine) TraverseBlocks(ctx context.Context, s schema.RO, x Ref, enter func(ctx context.Context, id blobcache.CID) (bool, error), exit func(ctx context.Context, b bigblob.Block) error) error {
+	return ag.bbag.TraverseBlocks(ctx, s, x.Root, enter, exit)
+}
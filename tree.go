@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"iter"
 	"os"
 	"path"
@@ -13,6 +14,7 @@ import (
 	"sort"
 	"strings"
 
+	"blobcache.io/blobcache/src/blobcache"
 	"blobcache.io/blobcache/src/schema"
 	"blobcache.io/glfs/bigblob"
 	"go.brendoncarroll.net/exp/streams"
@@ -148,45 +150,49 @@ type WalkTreeFunc = func(prefix string, tree TreeEntry) error
 // WalkTree walks the tree and calls f with tree entries in lexigraphical order
 // file1.txt comes before file2.txt
 // dir1/ comes before dir1/file1.txt
+//
+// If f returns fs.SkipDir, the entry's children, or its remaining siblings if it is not a tree, are skipped.
+// If f returns fs.SkipAll, the walk ends and WalkTree returns nil.
 func (ag *Machine) WalkTree(ctx context.Context, store schema.RO, ref Ref, f WalkTreeFunc) error {
-	return ag.walkTree(ctx, store, ref, f, "")
-}
-
-func (ag *Machine) walkTree(ctx context.Context, store schema.RO, ref Ref, f WalkTreeFunc, prefix string) error {
-	// TODO: use TreeReader
-	ents, err := ag.GetTreeSlice(ctx, store, ref, 1e6)
-	if err != nil {
-		return err
-	}
-	for _, ent := range ents {
-		if err := f(prefix, ent); err != nil {
+	w := ag.Walk(ctx, store, ref)
+	for p, ent := range w.All() {
+		switch err := f(parentPath(p), ent); {
+		case err == nil:
+		case err == fs.SkipDir:
+			w.SkipDir()
+		case err == fs.SkipAll:
+			return nil
+		default:
 			return err
 		}
-		if ent.Ref.Type == TypeTree {
-			p2 := path.Join(prefix, ent.Name)
-			if err := ag.walkTree(ctx, store, ent.Ref, f, p2); err != nil {
-				return err
-			}
-		}
 	}
-	return nil
+	return w.Err()
 }
 
 type RefWalker func(ref Ref) error
 
 // WalkRefs calls fn with every Ref reacheable from ref, including Ref. The only guarentee about order is bottom up.
-// if a tree is encoutered the child refs will be visited first.
+// if a tree, commit, or other registered type is encoutered the child refs will be visited first.
+// Each commit is visited once, even if it is reachable through more than one merge.
+// An error is returned if an object of a Type which has not been registered with the Machine is reachable from ref.
 func (ag *Machine) WalkRefs(ctx context.Context, s schema.RO, ref Ref, fn RefWalker) error {
-	if ref.Type == TypeTree {
-		// TODO: use tree reader
-		ents, err := ag.GetTreeSlice(ctx, s, ref, 1e6)
-		if err != nil {
-			return err
+	return ag.walkRefs(ctx, s, ref, fn, map[blobcache.CID]struct{}{})
+}
+
+func (ag *Machine) walkRefs(ctx context.Context, s schema.RO, ref Ref, fn RefWalker, seenCommits map[blobcache.CID]struct{}) error {
+	if ref.Type == TypeCommit {
+		if _, exists := seenCommits[ref.CID]; exists {
+			return nil
 		}
-		for _, ent := range ents {
-			if err := ag.WalkRefs(ctx, s, ent.Ref, fn); err != nil {
-				return err
-			}
+		seenCommits[ref.CID] = struct{}{}
+	}
+	refs, err := ag.ChildRefs(ctx, s, ref)
+	if err != nil {
+		return err
+	}
+	for _, ref2 := range refs {
+		if err := ag.walkRefs(ctx, s, ref2, fn, seenCommits); err != nil {
+			return err
 		}
 	}
 	return fn(ref)
@@ -0,0 +1,164 @@
+package glfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"blobcache.io/blobcache/src/schema"
+	"go.brendoncarroll.net/exp/streams"
+)
+
+// TypeSpec describes how a Machine should handle objects of a Type.
+// All of the fields are optional.
+type TypeSpec struct {
+	// Decode parses the content of an object.
+	// If Decode is nil, the content is left as a []byte.
+	Decode func(data []byte) (any, error)
+	// Encode is the inverse of Decode.
+	// If Encode is nil, only []byte can be posted.
+	Encode func(x any) ([]byte, error)
+	// Refs returns the Refs directly referenced by an object, after it has been decoded.
+	// If Refs is nil, objects of the type do not reference anything.
+	Refs func(x any) ([]Ref, error)
+}
+
+// WithType registers a Type with the Machine.
+// Sync, Traverse, WalkRefs, Verify and GC use the TypeSpec to find the Refs contained in objects of type ty.
+// The types provided by glfs are always registered, and cannot be overridden.
+func WithType(ty Type, spec TypeSpec) Option {
+	return func(ag *Machine) {
+		ag.types[ty] = spec
+	}
+}
+
+func builtinTypes(ag *Machine) map[Type]TypeSpec {
+	return map[Type]TypeSpec{
+		TypeBlob: {},
+		TypeTree: {
+			Decode: func(data []byte) (any, error) {
+				return streams.Collect(context.Background(), ag.ReadTreeFrom(bytes.NewReader(data)), 1e6)
+			},
+			Refs: func(x any) ([]Ref, error) {
+				var refs []Ref
+				for _, ent := range x.([]TreeEntry) {
+					refs = append(refs, ent.Ref)
+				}
+				return refs, nil
+			},
+		},
+		TypeCommit: {
+			Decode: func(data []byte) (any, error) {
+				return readCommit(bytes.NewReader(data))
+			},
+			Encode: func(x any) ([]byte, error) {
+				return json.Marshal(x)
+			},
+			Refs: func(x any) ([]Ref, error) {
+				return x.(*Commit).refs(), nil
+			},
+		},
+	}
+}
+
+// HasType returns true if objects of type ty can be understood by the Machine.
+func (ag *Machine) HasType(ty Type) bool {
+	_, exists := ag.types[ty]
+	return exists
+}
+
+func (ag *Machine) typeSpec(ty Type) (TypeSpec, error) {
+	spec, exists := ag.types[ty]
+	if !exists {
+		return TypeSpec{}, fmt.Errorf("unrecognized type %s", ty)
+	}
+	return spec, nil
+}
+
+// PostValue encodes x using the TypeSpec registered for ty, and posts it.
+func (ag *Machine) PostValue(ctx context.Context, s schema.WO, ty Type, x any) (*Ref, error) {
+	spec, err := ag.typeSpec(ty)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	switch {
+	case spec.Encode != nil:
+		if data, err = spec.Encode(x); err != nil {
+			return nil, err
+		}
+	default:
+		var ok bool
+		if data, ok = x.([]byte); !ok {
+			return nil, fmt.Errorf("type %s has no encoder for %T", ty, x)
+		}
+	}
+	return ag.PostTyped(ctx, s, ty, bytes.NewReader(data))
+}
+
+// GetValue retrieves the object at ref, and decodes it using the TypeSpec registered for ref.Type.
+func (ag *Machine) GetValue(ctx context.Context, s schema.RO, ref Ref) (any, error) {
+	spec, err := ag.typeSpec(ref.Type)
+	if err != nil {
+		return nil, err
+	}
+	r, err := ag.GetTyped(ctx, s, ref.Type, ref)
+	if err != nil {
+		return nil, err
+	}
+	return decodeValue(spec, r)
+}
+
+// ChildRefs returns the Refs directly referenced by the object at ref.
+// Blobs have no children, a tree's children are the Refs in its entries.
+func (ag *Machine) ChildRefs(ctx context.Context, s schema.RO, ref Ref) ([]Ref, error) {
+	switch ref.Type {
+	case TypeBlob:
+		return nil, nil
+	case TypeTree:
+		var refs []Ref
+		for ent, err := range ag.Entries(ctx, s, ref) {
+			if err != nil {
+				return nil, err
+			}
+			refs = append(refs, ent.Ref)
+		}
+		return refs, nil
+	}
+	spec, err := ag.typeSpec(ref.Type)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Refs == nil {
+		return nil, nil
+	}
+	r, err := ag.GetTyped(ctx, s, ref.Type, ref)
+	if err != nil {
+		return nil, err
+	}
+	return readRefs(spec, r)
+}
+
+func decodeValue(spec TypeSpec, r io.Reader) (any, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Decode == nil {
+		return data, nil
+	}
+	return spec.Decode(data)
+}
+
+func readRefs(spec TypeSpec, r io.Reader) ([]Ref, error) {
+	if spec.Refs == nil {
+		return nil, nil
+	}
+	x, err := decodeValue(spec, r)
+	if err != nil {
+		return nil, err
+	}
+	return spec.Refs(x)
+}
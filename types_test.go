@@ -0,0 +1,185 @@
+package glfs
+
+import (
+	"context"
+	"encoding/json"
+	"iter"
+	"sync"
+	"testing"
+
+	"blobcache.io/blobcache/src/blobcache"
+	"blobcache.io/blobcache/src/schema"
+	"github.com/stretchr/testify/require"
+)
+
+const typeRefList = Type("reflist")
+
+// refListType is a custom type, which is a JSON list of Refs.
+var refListType = TypeSpec{
+	Decode: func(data []byte) (any, error) {
+		var refs []Ref
+		if err := json.Unmarshal(data, &refs); err != nil {
+			return nil, err
+		}
+		return refs, nil
+	},
+	Encode: func(x any) ([]byte, error) {
+		return json.Marshal(x)
+	},
+	Refs: func(x any) ([]Ref, error) {
+		return x.([]Ref), nil
+	},
+}
+
+func TestCustomType(t *testing.T) {
+	ctx := context.TODO()
+	ag := NewMachine(WithType(typeRefList, refListType))
+	require.True(t, ag.HasType(typeRefList))
+	require.False(t, defaultOp.HasType(typeRefList))
+
+	s := newStore(t)
+	tree := mustPostTree(t, s, map[string]Ref{
+		"a.txt": mustPostBlob(t, s, []byte("a")),
+		"b.txt": mustPostBlob(t, s, []byte("b")),
+	})
+	blob := mustPostBlob(t, s, []byte("c"))
+	ref, err := ag.PostValue(ctx, s, typeRefList, []Ref{tree, blob})
+	require.NoError(t, err)
+
+	x, err := ag.GetValue(ctx, s, *ref)
+	require.NoError(t, err)
+	require.Equal(t, []Ref{tree, blob}, x)
+	children, err := ag.ChildRefs(ctx, s, *ref)
+	require.NoError(t, err)
+	require.Equal(t, []Ref{tree, blob}, children)
+
+	dst := newStore(t)
+	require.NoError(t, ag.Sync(ctx, dst, s, *ref))
+	require.NoError(t, ag.Verify(ctx, dst, *ref))
+	var count int
+	require.NoError(t, ag.WalkRefs(ctx, dst, *ref, func(Ref) error {
+		count++
+		return nil
+	}))
+	// the list, the tree, its 2 blobs, and the other blob
+	require.Equal(t, 5, count)
+
+	// the default Machine does not know about the type
+	require.Error(t, defaultOp.Sync(ctx, newStore(t), s, *ref))
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.TODO()
+	s := newStore(t)
+	a := mustPostBlob(t, s, []byte("a"))
+	root := mustPostTree(t, s, map[string]Ref{
+		"a.txt":   a,
+		"b/c.txt": mustPostBlob(t, s, []byte("c")),
+	})
+	require.NoError(t, defaultOp.Verify(ctx, s, root))
+
+	require.NoError(t, s.Delete(ctx, []blobcache.CID{a.CID}))
+	err := defaultOp.Verify(ctx, s, root)
+	require.ErrorIs(t, err, blobcache.ErrNotFound{CID: a.CID})
+}
+
+func TestGC(t *testing.T) {
+	ctx := context.TODO()
+	s := newListStore(t)
+	keep1 := mustPostTree(t, s, map[string]Ref{
+		"a.txt":  mustPostBlob(t, s, []byte("a")),
+		"shared": mustPostBlob(t, s, []byte("shared")),
+	})
+	keep2 := mustPostTree(t, s, map[string]Ref{
+		"b.txt":  mustPostBlob(t, s, []byte("b")),
+		"shared": mustPostBlob(t, s, []byte("shared")),
+	})
+	mustPostTree(t, s, map[string]Ref{
+		"garbage.txt": mustPostBlob(t, s, []byte("garbage")),
+	})
+	before := s.Len()
+
+	res, err := defaultOp.GC(ctx, s, keep1, keep2)
+	require.NoError(t, err)
+	require.Equal(t, 2, res.Deleted)
+	require.Equal(t, before-2, s.Len())
+	require.Equal(t, s.Len(), res.Reachable)
+	require.NoError(t, defaultOp.Verify(ctx, s, keep1))
+	require.NoError(t, defaultOp.Verify(ctx, s, keep2))
+
+	res, err = defaultOp.GC(ctx, s, keep1, keep2)
+	require.NoError(t, err)
+	require.Equal(t, 0, res.Deleted)
+}
+
+func TestGCUnknownType(t *testing.T) {
+	ctx := context.TODO()
+	ag := NewMachine(WithType(typeRefList, refListType))
+	s := newListStore(t)
+	blob := mustPostBlob(t, s, []byte("only referenced by the list"))
+	list, err := ag.PostValue(ctx, s, typeRefList, []Ref{blob})
+	require.NoError(t, err)
+	before := s.Len()
+
+	// the default Machine cannot find the children of the list, so it must not delete them.
+	_, err = defaultOp.GC(ctx, s, *list)
+	require.Error(t, err)
+	require.Equal(t, before, s.Len())
+	require.Error(t, defaultOp.Verify(ctx, s, *list))
+	require.Error(t, defaultOp.WalkRefs(ctx, s, *list, func(Ref) error { return nil }))
+
+	res, err := ag.GC(ctx, s, *list)
+	require.NoError(t, err)
+	require.Equal(t, 0, res.Deleted)
+}
+
+// listStore adds List to a MemStore, so that it can be used with GC.
+type listStore struct {
+	*schema.MemStore
+
+	mu   sync.Mutex
+	cids map[blobcache.CID]struct{}
+}
+
+func newListStore(t testing.TB) *listStore {
+	return &listStore{MemStore: newStore(t), cids: map[blobcache.CID]struct{}{}}
+}
+
+func (s *listStore) Post(ctx context.Context, data []byte) (blobcache.CID, error) {
+	cid, err := s.MemStore.Post(ctx, data)
+	if err != nil {
+		return cid, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cids[cid] = struct{}{}
+	return cid, nil
+}
+
+func (s *listStore) Delete(ctx context.Context, cids []blobcache.CID) error {
+	if err := s.MemStore.Delete(ctx, cids); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cid := range cids {
+		delete(s.cids, cid)
+	}
+	return nil
+}
+
+func (s *listStore) List(ctx context.Context) iter.Seq2[blobcache.CID, error] {
+	return func(yield func(blobcache.CID, error) bool) {
+		s.mu.Lock()
+		cids := make([]blobcache.CID, 0, len(s.cids))
+		for cid := range s.cids {
+			cids = append(cids, cid)
+		}
+		s.mu.Unlock()
+		for _, cid := range cids {
+			if !yield(cid, nil) {
+				return
+			}
+		}
+	}
+}
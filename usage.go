@@ -0,0 +1,156 @@
+package glfs
+
+import (
+	"context"
+	"path"
+
+	"blobcache.io/blobcache/src/blobcache"
+	"blobcache.io/blobcache/src/schema"
+	"blobcache.io/glfs/bigblob"
+)
+
+// Usage is an account of the storage used by some part of a filesystem.
+type Usage struct {
+	// LogicalBytes is the sum of the sizes of all the files, counting each path separately.
+	LogicalBytes uint64
+	// Files is the number of blobs, counting each path separately.
+	Files int
+	// Dirs is the number of trees, counting each path separately.
+	Dirs int
+
+	// Blocks is the number of unique blocks.
+	Blocks int
+	// StoredBytes is the total size of the unique blocks as ciphertext.
+	// This includes the blocks for trees and the index blocks of large objects.
+	StoredBytes uint64
+}
+
+func (u *Usage) add(x Usage) {
+	u.LogicalBytes += x.LogicalBytes
+	u.Files += x.Files
+	u.Dirs += x.Dirs
+	u.Blocks += x.Blocks
+	u.StoredBytes += x.StoredBytes
+}
+
+// UsageReport is returned by Machine.Usage
+type UsageReport struct {
+	// Total is the usage of all the roots together.
+	// Blocks shared by more than one root are only counted once.
+	Total Usage
+	// Roots has an entry for each root, in the order they were passed to Usage.
+	Roots []RootUsage
+}
+
+// RootUsage is the usage of a single root, as part of a UsageReport.
+type RootUsage struct {
+	Root Ref
+	Usage
+	// ByDir maps the path of each directory to the usage of everything beneath it.
+	// The root directory is at "".
+	ByDir map[string]Usage
+}
+
+// Usage accounts for the storage used by roots.
+//
+// Like du, each unique block is attributed to the first path, in the first root, which references it.
+// So the Blocks and StoredBytes of a root, or a directory in it, are what it costs in addition to the roots and paths visited before it.
+// LogicalBytes, Files and Dirs are not deduplicated.
+func (ag *Machine) Usage(ctx context.Context, s schema.RO, roots ...Ref) (*UsageReport, error) {
+	uc := ag.newUsageCounter(s)
+	var report UsageReport
+	for _, root := range roots {
+		ru := RootUsage{Root: root, ByDir: map[string]Usage{}}
+		u, err := uc.count(ctx, root, "", ru.ByDir)
+		if err != nil {
+			return nil, err
+		}
+		ru.Usage = *u
+		report.Total.add(*u)
+		report.Roots = append(report.Roots, ru)
+	}
+	return &report, nil
+}
+
+// MarginalUsage returns the usage of x, when the blocks reachable from others have already been paid for.
+// The Blocks and StoredBytes of the result are the cost of adding x to a store which already contains others.
+func (ag *Machine) MarginalUsage(ctx context.Context, s schema.RO, x Ref, others ...Ref) (*Usage, error) {
+	uc := ag.newUsageCounter(s)
+	for _, other := range others {
+		if _, err := uc.count(ctx, other, "", nil); err != nil {
+			return nil, err
+		}
+	}
+	return uc.count(ctx, x, "", nil)
+}
+
+type usageCounter struct {
+	ag   *Machine
+	s    schema.RO
+	seen map[blobcache.CID]struct{}
+}
+
+func (ag *Machine) newUsageCounter(s schema.RO) *usageCounter {
+	return &usageCounter{ag: ag, s: s, seen: map[blobcache.CID]struct{}{}}
+}
+
+// count returns the usage of ref, which is at path p.
+// If byDir is not nil, the usage of each tree is added to it.
+func (uc *usageCounter) count(ctx context.Context, ref Ref, p string, byDir map[string]Usage) (*Usage, error) {
+	var u Usage
+	if err := uc.ag.bbag.TraverseBlocks(ctx, uc.s, ref.Root,
+		func(ctx context.Context, id blobcache.CID) (bool, error) {
+			_, exists := uc.seen[id]
+			uc.seen[id] = struct{}{}
+			return !exists, nil
+		},
+		func(ctx context.Context, b bigblob.Block) error {
+			u.Blocks++
+			u.StoredBytes += uint64(b.Size)
+			return nil
+		},
+	); err != nil {
+		return nil, err
+	}
+	switch ref.Type {
+	case TypeBlob:
+		u.Files++
+		u.LogicalBytes += ref.Size
+	case TypeTree:
+		u.Dirs++
+		for ent, err := range uc.ag.Entries(ctx, uc.s, ref) {
+			if err != nil {
+				return nil, err
+			}
+			u2, err := uc.count(ctx, ent.Ref, path.Join(p, ent.Name), byDir)
+			if err != nil {
+				return nil, err
+			}
+			u.add(*u2)
+		}
+		if byDir != nil {
+			byDir[p] = u
+		}
+	default:
+		if !uc.ag.HasType(ref.Type) {
+			break
+		}
+		refs, err := uc.ag.ChildRefs(ctx, uc.s, ref)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref2 := range refs {
+			// only the first tree at p is reported, so a commit's parents do not replace its root.
+			childDirs := byDir
+			if _, exists := byDir[p]; exists {
+				childDirs = nil
+			}
+			u2, err := uc.count(ctx, ref2, p, childDirs)
+			if err != nil {
+				return nil, err
+			}
+			u.add(*u2)
+		}
+	}
+	return &u, nil
+}
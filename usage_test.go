@@ -0,0 +1,59 @@
+package glfs
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsage(t *testing.T) {
+	ctx := context.TODO()
+	s := newStore(t)
+	big := bytes.Repeat([]byte("0123456789"), DefaultBlockSize/5)
+	bigRef := mustPostBlob(t, s, big)
+	small := mustPostBlob(t, s, []byte("small"))
+	root1 := mustPostTree(t, s, map[string]Ref{
+		"a/big.bin":   bigRef,
+		"b/big.bin":   bigRef,
+		"b/small.txt": small,
+	})
+
+	report, err := defaultOp.Usage(ctx, s, root1)
+	require.NoError(t, err)
+	u := report.Roots[0].Usage
+	require.Equal(t, report.Total, u)
+	require.Equal(t, uint64(2*len(big)+5), u.LogicalBytes)
+	require.Equal(t, 3, u.Files)
+	require.Equal(t, 3, u.Dirs)
+	// 2 data blocks and 1 index block for big.bin, 1 block for small.txt, and 3 trees.
+	require.Equal(t, 7, u.Blocks)
+	require.Less(t, u.StoredBytes, u.LogicalBytes)
+
+	byDir := report.Roots[0].ByDir
+	require.Len(t, byDir, 3)
+	require.Equal(t, u, byDir[""])
+	// big.bin is attributed to a, which comes first
+	require.Equal(t, uint64(len(big)), byDir["a"].LogicalBytes)
+	require.Greater(t, byDir["a"].StoredBytes, uint64(len(big)))
+	require.Equal(t, uint64(len(big)+5), byDir["b"].LogicalBytes)
+	require.Equal(t, 2, byDir["b"].Blocks)
+
+	// root2 only adds a single file to root1
+	root2, err := defaultOp.Apply(ctx, s, s, root1, ChangeSet{
+		{Path: "c.txt", Op: EditPut, Ref: &small},
+	})
+	require.NoError(t, err)
+	marginal, err := defaultOp.MarginalUsage(ctx, s, *root2, root1)
+	require.NoError(t, err)
+	require.Equal(t, 4, marginal.Files)
+	require.Equal(t, 1, marginal.Blocks)
+
+	report, err = defaultOp.Usage(ctx, s, root1, *root2)
+	require.NoError(t, err)
+	require.Len(t, report.Roots, 2)
+	require.Equal(t, 1, report.Roots[1].Blocks)
+	require.Equal(t, 8, report.Total.Blocks)
+	require.Equal(t, 7, report.Total.Files)
+}
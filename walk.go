@@ -0,0 +1,261 @@
+package glfs
+
+import (
+	"context"
+	"iter"
+	"path"
+	"strings"
+	"sync"
+
+	"blobcache.io/blobcache/src/schema"
+	"go.brendoncarroll.net/exp/streams"
+	"golang.org/x/sync/semaphore"
+)
+
+// Entries returns an iterator over the entries in the tree at ref, in order by name.
+// Entries are read from the store as they are needed, the tree is never loaded all at once.
+// If an error is encountered, it is yielded with an empty TreeEntry and iteration stops.
+func (ag *Machine) Entries(ctx context.Context, s schema.RO, ref Ref) iter.Seq2[TreeEntry, error] {
+	return func(yield func(TreeEntry, error) bool) {
+		tr, err := ag.NewTreeReader(s, ref)
+		if err != nil {
+			yield(TreeEntry{}, err)
+			return
+		}
+		for {
+			ent, err := streams.Next(ctx, tr)
+			if err != nil {
+				if !streams.IsEOS(err) {
+					yield(TreeEntry{}, err)
+				}
+				return
+			}
+			if !yield(ent, nil) {
+				return
+			}
+		}
+	}
+}
+
+// WalkOption configures a Walker
+type WalkOption func(*Walker)
+
+// WithPrefetch causes the Walker to load up to n child trees concurrently, ahead of the walk.
+// The order of the walk is unaffected.
+func WithPrefetch(n int) WalkOption {
+	return func(w *Walker) {
+		w.prefetch = n
+	}
+}
+
+// Walker iterates over every entry reachable from a root tree.
+// Create one with Machine.Walk
+type Walker struct {
+	ag       *Machine
+	ctx      context.Context
+	s        schema.RO
+	root     Ref
+	prefetch int
+
+	sem  *semaphore.Weighted
+	skip bool
+	err  error
+}
+
+// Walk returns a Walker over the tree at root.
+// Nothing is read until the Walker is iterated with All.
+func (ag *Machine) Walk(ctx context.Context, s schema.RO, root Ref, opts ...WalkOption) *Walker {
+	w := &Walker{
+		ag:   ag,
+		ctx:  ctx,
+		s:    s,
+		root: root,
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// All yields the path of each entry relative to the root, and the entry itself.
+// Entries are yielded in lexigraphical order, a tree is yielded before its children.
+//
+// Breaking out of the loop has the same effect as returning fs.SkipAll from a fs.WalkDirFunc.
+// Calling SkipDir from the body of the loop has the same effect as returning fs.SkipDir.
+// Err should be checked after the loop has finished.
+func (w *Walker) All() iter.Seq2[string, TreeEntry] {
+	return func(yield func(string, TreeEntry) bool) {
+		ctx, cf := context.WithCancel(w.ctx)
+		defer cf()
+		w.err = nil
+		if w.prefetch > 0 {
+			w.sem = semaphore.NewWeighted(int64(w.prefetch))
+		}
+		w.walk(ctx, "", w.root, nil, yield)
+	}
+}
+
+// SkipDir causes the walk to skip the entry most recently yielded.
+// If the entry is a tree, none of its children will be visited.
+// If the entry is not a tree, the remaining entries in its parent will not be visited.
+func (w *Walker) SkipDir() {
+	w.skip = true
+}
+
+// Err returns the error, if any, which caused the walk to end early.
+func (w *Walker) Err() error {
+	return w.err
+}
+
+// walk visits the children of the tree at ref, and returns false if the walk should stop.
+// If pre is not nil, the children are taken from it instead of the store.
+func (w *Walker) walk(ctx context.Context, prefix string, ref Ref, pre *treeFuture, yield func(string, TreeEntry) bool) bool {
+	if w.sem == nil {
+		return w.walkSeq(ctx, prefix, w.ag.Entries(ctx, w.s, ref), nil, yield)
+	}
+	var ents []TreeEntry
+	if pre != nil {
+		<-pre.done
+		if pre.err != nil {
+			w.err = pre.err
+			return false
+		}
+		ents = pre.ents
+	} else {
+		var err error
+		if ents, err = w.ag.GetTreeSlice(ctx, w.s, ref, 1e6); err != nil {
+			w.err = err
+			return false
+		}
+	}
+	pf := w.newPrefetcher(ctx, ents)
+	defer pf.close()
+	return w.walkSeq(ctx, prefix, func(yield func(TreeEntry, error) bool) {
+		for _, ent := range ents {
+			if !yield(ent, nil) {
+				return
+			}
+		}
+	}, pf, yield)
+}
+
+func (w *Walker) walkSeq(ctx context.Context, prefix string, ents iter.Seq2[TreeEntry, error], pf *prefetcher, yield func(string, TreeEntry) bool) bool {
+	i := -1
+	for ent, err := range ents {
+		if err != nil {
+			w.err = err
+			return false
+		}
+		i++
+		p := path.Join(prefix, ent.Name)
+		w.skip = false
+		if !yield(p, ent) {
+			return false
+		}
+		if w.skip {
+			w.skip = false
+			if ent.Ref.Type != TypeTree {
+				return true
+			}
+			continue
+		}
+		if ent.Ref.Type == TypeTree {
+			var pre *treeFuture
+			if pf != nil {
+				pre = pf.take(i)
+			}
+			if !w.walk(ctx, p, ent.Ref, pre, yield) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// treeFuture holds the entries of a tree which is being loaded in the background.
+type treeFuture struct {
+	done chan struct{}
+	ents []TreeEntry
+	err  error
+}
+
+// prefetcher loads the child trees of a single tree, in order, ahead of the walk.
+// Each load holds a slot in the Walker's semaphore until it has been taken.
+type prefetcher struct {
+	w    *Walker
+	ctx  context.Context
+	cf   context.CancelFunc
+	wg   sync.WaitGroup
+	ents []TreeEntry
+	next int
+
+	futs map[int]*treeFuture
+}
+
+func (w *Walker) newPrefetcher(ctx context.Context, ents []TreeEntry) *prefetcher {
+	ctx, cf := context.WithCancel(ctx)
+	pf := &prefetcher{
+		w:    w,
+		ctx:  ctx,
+		cf:   cf,
+		ents: ents,
+		futs: make(map[int]*treeFuture),
+	}
+	pf.fill()
+	return pf
+}
+
+// fill starts loading child trees until there are no more slots available.
+func (pf *prefetcher) fill() {
+	for ; pf.next < len(pf.ents); pf.next++ {
+		ent := pf.ents[pf.next]
+		if ent.Ref.Type != TypeTree {
+			continue
+		}
+		if !pf.w.sem.TryAcquire(1) {
+			return
+		}
+		fut := &treeFuture{done: make(chan struct{})}
+		pf.futs[pf.next] = fut
+		pf.wg.Add(1)
+		go func() {
+			defer pf.wg.Done()
+			defer close(fut.done)
+			fut.ents, fut.err = pf.w.ag.GetTreeSlice(pf.ctx, pf.w.s, ent.Ref, 1e6)
+		}()
+	}
+}
+
+// take returns the future for the i-th entry, or nil if it was not prefetched.
+func (pf *prefetcher) take(i int) *treeFuture {
+	pf.next = max(pf.next, i+1)
+	fut, exists := pf.futs[i]
+	if !exists {
+		pf.fill()
+		return nil
+	}
+	delete(pf.futs, i)
+	<-fut.done
+	pf.w.sem.Release(1)
+	pf.fill()
+	return fut
+}
+
+// close cancels any loads which have not been taken, and releases their slots.
+func (pf *prefetcher) close() {
+	pf.cf()
+	pf.wg.Wait()
+	for i := range pf.futs {
+		pf.w.sem.Release(1)
+		delete(pf.futs, i)
+	}
+}
+
+// parentPath returns the path of the tree containing p
+func parentPath(p string) string {
+	i := strings.LastIndexByte(p, '/')
+	if i < 0 {
+		return ""
+	}
+	return p[:i]
+}
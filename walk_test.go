@@ -0,0 +1,90 @@
+package glfs
+
+import (
+	"context"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalk(t *testing.T) {
+	ctx := context.TODO()
+	s := newStore(t)
+	root := mustPostTree(t, s, generateTree(t, s, 100))
+
+	var expected []string
+	require.NoError(t, WalkTree(ctx, s, root, func(prefix string, ent TreeEntry) error {
+		expected = append(expected, prefix+"/"+ent.Name)
+		return nil
+	}))
+	require.Len(t, expected, 110)
+
+	for _, opts := range [][]WalkOption{nil, {WithPrefetch(4)}} {
+		w := defaultOp.Walk(ctx, s, root, opts...)
+		var actual []string
+		for p, ent := range w.All() {
+			actual = append(actual, parentPath(p)+"/"+ent.Name)
+		}
+		require.NoError(t, w.Err())
+		require.Equal(t, expected, actual)
+	}
+}
+
+func TestWalkSkip(t *testing.T) {
+	ctx := context.TODO()
+	s := newStore(t)
+	root := mustPostTree(t, s, map[string]Ref{
+		"a/1": blobRef(t, s),
+		"a/2": blobRef(t, s),
+		"b/1": blobRef(t, s),
+		"b/2": blobRef(t, s),
+		"c/1": blobRef(t, s),
+	})
+
+	for _, opts := range [][]WalkOption{nil, {WithPrefetch(2)}} {
+		w := defaultOp.Walk(ctx, s, root, opts...)
+		var actual []string
+		for p := range w.All() {
+			actual = append(actual, p)
+			switch p {
+			case "a", "b/1":
+				w.SkipDir()
+			}
+			if p == "c" {
+				break
+			}
+		}
+		require.NoError(t, w.Err())
+		require.Equal(t, []string{"a", "b", "b/1", "c"}, actual)
+	}
+
+	var actual []string
+	require.NoError(t, WalkTree(ctx, s, root, func(prefix string, ent TreeEntry) error {
+		actual = append(actual, prefix+"/"+ent.Name)
+		if ent.Name == "b" {
+			return fs.SkipAll
+		}
+		return nil
+	}))
+	require.Equal(t, []string{"/a", "a/1", "a/2", "/b"}, actual)
+}
+
+func TestEntries(t *testing.T) {
+	ctx := context.TODO()
+	s := newStore(t)
+	root := mustPostTree(t, s, map[string]Ref{
+		"a": blobRef(t, s),
+		"b": blobRef(t, s),
+	})
+	var names []string
+	for ent, err := range defaultOp.Entries(ctx, s, root) {
+		require.NoError(t, err)
+		names = append(names, ent.Name)
+	}
+	require.Equal(t, []string{"a", "b"}, names)
+
+	for _, err := range defaultOp.Entries(ctx, s, blobRef(t, s)) {
+		require.ErrorIs(t, err, ErrRefType{Have: TypeBlob, Want: TypeTree})
+	}
+}